@@ -0,0 +1,280 @@
+package cli
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type Arg struct {
+    name      string
+    desc      string
+    mandatory bool
+    variadic  bool
+    argType   optionType
+    choices   []string
+    setter    func(string) error
+    restore   func()
+}
+
+func (a *Arg) String() string {
+    name := a.name
+    if a.variadic {
+        name += "..."
+    }
+    if a.mandatory {
+        return "<" + name + ">"
+    }
+    return "[" + name + "]"
+}
+
+func (a *Arg) description() string {
+    description := a.desc
+    if len(a.choices) > 0 {
+        description += " {" + strings.Join(a.choices, "|") + "}"
+    } else if a.argType != value {
+        description += " (" + string(a.argType) + ")"
+    }
+    return strings.TrimSpace(description)
+}
+
+func (a *Arg) set(val string) error {
+    if len(a.choices) > 0 {
+        found := false
+        for _, choice := range a.choices {
+            found = found || val == choice
+        }
+        if !found {
+            return fmt.Errorf("invalid choice %q, expected one of %s", val, strings.Join(a.choices, ", "))
+        }
+    }
+    if a.setter == nil {
+        return nil
+    }
+    return a.setter(val)
+}
+
+func newArgument(argType optionType, name string, description string, setter func(string) error) *Arg {
+    if description != "" {
+        description = Sentence(description)
+    }
+    return &Arg{
+        name:    name,
+        desc:    description,
+        argType: argType,
+        setter:  setter}
+}
+
+func boundArg(arg *Arg, value interface{}) *Arg {
+    arg.restore = restorer(value, "argument "+arg.String())
+    return arg
+}
+
+func (a *Arg) reset() {
+    if a.restore != nil {
+        a.restore()
+    }
+}
+
+func Argument(name string) *Arg {
+    return newArgument(value, name, "", nil)
+}
+
+func Mandatory(arg *Arg) *Arg {
+    arg.mandatory = true
+    return arg
+}
+
+// variadic argument takes all remaining arguments, it has to be the last one
+func Variadic(arg *Arg) *Arg {
+    arg.variadic = true
+    return arg
+}
+
+func ArgFunc(handler func(string) error, name string, description string) *Arg {
+    return newArgument(value, name, description, handler)
+}
+
+func StringArg(value *string, name string, description string) *Arg {
+    return boundArg(ArgFunc(func(val string) error {
+        *value = val
+        return nil
+    }, name, description), value)
+}
+
+func StringsArg(value *[]string, name string, description string) *Arg {
+    return boundArg(Variadic(ArgFunc(func(val string) error {
+        *value = append(*value, val)
+        return nil
+    }, name, description)), value)
+}
+
+func IntArg(value *int64, name string, description string) *Arg {
+    return boundArg(newArgument(integer, name, description, func(val string) error {
+        number, err := strconv.ParseInt(val, 10, 64)
+        if err != nil {
+            return err
+        }
+        *value = number
+        return nil
+    }), value)
+}
+
+func FloatArg(value *float64, name string, description string) *Arg {
+    return boundArg(newArgument(float, name, description, func(val string) error {
+        number, err := strconv.ParseFloat(val, 64)
+        if err != nil {
+            return err
+        }
+        *value = number
+        return nil
+    }), value)
+}
+
+func DurationArg(value *time.Duration, name string, description string) *Arg {
+    return boundArg(newArgument(duration, name, description, func(val string) error {
+        duration, err := time.ParseDuration(val)
+        if err != nil {
+            return err
+        }
+        *value = duration
+        return nil
+    }), value)
+}
+
+func PathArg(value *string, name string, description string) *Arg {
+    return boundArg(newArgument(path, name, description, func(val string) error {
+        *value = val
+        return nil
+    }), value)
+}
+
+func ChoiceArg(value *string, choices []string, name string, description string) *Arg {
+    arg := newArgument(choice, name, description, func(val string) error {
+        *value = val
+        return nil
+    })
+    arg.choices = choices
+    return boundArg(arg, value)
+}
+
+// binds given values to declared arguments, commands without declared arguments accept anything
+func bindArguments(args []*Arg, values []string) error {
+    if len(args) == 0 {
+        return nil
+    }
+    for index, arg := range args {
+        var given []string
+        if index < len(values) {
+            given = values[index : index+1]
+            if arg.variadic {
+                given = values[index:]
+            }
+        }
+        if len(given) == 0 && arg.mandatory {
+            return errors.New("Missing argument " + arg.String())
+        }
+        for _, val := range given {
+            if err := arg.set(val); err != nil {
+                return fmt.Errorf("Invalid argument %s %q: %s", arg.String(), val, err)
+            }
+        }
+    }
+    return nil
+}
+
+// accepted number of arguments, negative max means unlimited
+type ArgCount struct {
+    min int
+    max int
+}
+
+func NoArgs() ArgCount {
+    return ArgCount{0, 0}
+}
+
+func checkArgCount(count int) {
+    if count < 0 {
+        panic(fmt.Sprintf("Invalid number of arguments %d", count))
+    }
+}
+
+func ExactArgs(count int) ArgCount {
+    checkArgCount(count)
+    return ArgCount{count, count}
+}
+
+func MinArgs(min int) ArgCount {
+    checkArgCount(min)
+    return ArgCount{min, -1}
+}
+
+func MaxArgs(max int) ArgCount {
+    checkArgCount(max)
+    return ArgCount{0, max}
+}
+
+func RangeArgs(min int, max int) ArgCount {
+    if min < 0 || max < min {
+        panic(fmt.Sprintf("Invalid range of arguments %d..%d", min, max))
+    }
+    return ArgCount{min, max}
+}
+
+// count derived from declared arguments
+func argCountOf(args []*Arg) ArgCount {
+    count := ArgCount{0, len(args)}
+    for _, arg := range args {
+        if arg.mandatory {
+            count.min++
+        }
+        if arg.variadic {
+            count.max = -1
+        }
+    }
+    return count
+}
+
+func (a ArgCount) accepts(count int) bool {
+    return count >= a.min && (a.max < 0 || count <= a.max)
+}
+
+func plural(count int) string {
+    if count == 1 {
+        return "1 argument"
+    }
+    return fmt.Sprintf("%d arguments", count)
+}
+
+func (a ArgCount) String() string {
+    switch {
+    case a.max == 0:
+        return "no arguments"
+    case a.min == a.max:
+        return "exactly " + plural(a.min)
+    case a.max < 0:
+        return "at least " + plural(a.min)
+    case a.min == 0:
+        return "at most " + plural(a.max)
+    default:
+        return fmt.Sprintf("%d to %d arguments", a.min, a.max)
+    }
+}
+
+// placeholders of arguments following the already declared ones
+func (a ArgCount) placeholders(declared int) string {
+    var placeholders string
+    index := declared
+    for ; index < a.min; index++ {
+        placeholders += " <ARG>"
+    }
+    if a.max < 0 {
+        return placeholders + " [ARG]..."
+    }
+    for ; index < a.max; index++ {
+        placeholders += " [ARG]"
+    }
+    return placeholders
+}
@@ -4,21 +4,39 @@ import (
     "os"
     "path/filepath"
     "fmt"
+    "strings"
 )
 
 const (
     DefaultVersion = "v0.0.1"
 )
 
+type ParsingMode int
+
+const (
+    // options are accepted only before the first argument of a command
+    PosixMode ParsingMode = iota
+    // options may appear anywhere among the arguments of a command
+    GnuMode
+)
+
 type Cli struct {
-    bin       string
-    name      string
-    desc      string
-    version   string
-    opts      map[string]*Option
-    shortOpts map[string]*Option
-    cmds      map[string]*Cmd
-    grps      map[string]*Grp
+    bin         string
+    name        string
+    desc        string
+    version     string
+    opts        map[string]*Option
+    shortOpts   map[string]*Option
+    cmds        map[string]*Cmd
+    grps        map[string]*Grp
+    constraints []*Constraint
+    mode        ParsingMode
+    abbrev      bool
+    strict      bool
+    envPrefix   string
+    configOpt   *Option
+    configPaths []string
+    printConfig bool
 }
 
 func Default(description string, options ...*Option) *Cli {
@@ -40,11 +58,53 @@ func New(description string, version string, options ...*Option) *Cli {
 
 func (c *Cli) AddVersion(version string) *Cli {
     c.version = version
-    return addOptions(c, FlagOptFunc(func() error {
+    return addOptions(c, builtin(FlagOptFunc(func() error {
         fmt.Println("version:", InfoStr(c.version))
         os.Exit(0)
         return nil
-    }, "version", 'v', "Show version and exit")).(*Cli)
+    }, "version", 'v', "Show version and exit"))).(*Cli)
+}
+
+func (c *Cli) SetParsingMode(mode ParsingMode) *Cli {
+    c.mode = mode
+    return c
+}
+
+// allows unambiguous prefixes of long options, commands and groups
+func (c *Cli) SetAbbreviations(allowed bool) *Cli {
+    c.abbrev = allowed
+    return c
+}
+
+// turns use of deprecated options, commands and groups into errors
+func (c *Cli) SetStrictDeprecations(strict bool) *Cli {
+    c.strict = strict
+    return c
+}
+
+// binds options to environment variables <PREFIX>_<LONG_NAME>
+func (c *Cli) SetEnvPrefix(prefix string) *Cli {
+    c.envPrefix = prefix
+    return c
+}
+
+// adds --config option, without it the first existing file of search paths is loaded
+func (c *Cli) AddConfig(searchPaths ...string) *Cli {
+    description := "load options from configuration file"
+    if len(searchPaths) > 0 {
+        description += ", defaults to the first found of " + strings.Join(searchPaths, ", ")
+    }
+    c.configPaths = searchPaths
+    c.configOpt = builtin(PathOptFunc(func(string) error {
+        // loaded before parsing
+        return nil
+    }, "config", NoShort, description))
+    return c.AddOptions(c.configOpt)
+}
+
+// adds --print-config option printing effective option values and their sources instead of running command
+func (c *Cli) AddPrintConfig() *Cli {
+    return c.AddOptions(Persistent(builtin(FlagOpt(&c.printConfig, "print-config", NoShort, "print effective option values and their sources"))))
 }
 
 func (c *Cli) AddHelp() *Cli {
@@ -55,6 +115,14 @@ func (c *Cli) AddOptions(options ...*Option) *Cli {
     return addOptions(c, options...).(*Cli)
 }
 
+func (c *Cli) AddConstraints(constraints ...*Constraint) *Cli {
+    return addConstraints(c, constraints...).(*Cli)
+}
+
+func (c *Cli) AddExclusive(options ...*Option) *Cli {
+    return c.AddConstraints(MutuallyExclusive(options...))
+}
+
 func (c *Cli) AddGroups(groups ...*Grp) *Cli {
     return addGroups(c, groups...).(*Cli)
 }
@@ -64,7 +132,9 @@ func (c *Cli) AddCommands(commands ...*Cmd) *Cli {
 }
 
 func (c *Cli) Handle(args []string) error {
-    return process(c, args, true)
+    resetState(c)
+    p := &parser{mode: c.mode, abbreviations: c.abbrev, strict: c.strict, envPrefix: c.envPrefix, printConfig: &c.printConfig}
+    return p.process(c, args, false)
 }
 
 func (c *Cli) Usage() {
@@ -79,6 +149,10 @@ func (c *Cli) shortOptions() map[string]*Option {
     return c.shortOpts
 }
 
+func (c *Cli) optionConstraints() []*Constraint {
+    return c.constraints
+}
+
 func (c *Cli) groups() map[string]*Grp {
     return c.grps
 }
@@ -87,6 +161,15 @@ func (c *Cli) commands() map[string]*Cmd {
     return c.cmds
 }
 
+func (c *Cli) parent() cmdInfo {
+    // cli is the root
+    return nil
+}
+
+func (c *Cli) setParent(parent cmdInfo) {
+    panic("Cli " + c.bin + " can't be nested")
+}
+
 func (c *Cli) trigger() string {
     return c.bin
 }
@@ -4,6 +4,11 @@ import (
     "testing"
     "fmt"
     "strings"
+    "strconv"
+    "os"
+    "io/ioutil"
+    "path/filepath"
+    "time"
 )
 
 var uppercase = false
@@ -41,3 +46,1003 @@ func TestCli(t *testing.T) {
         t.Error(err.Error())
     }
 }
+
+func TestOptionAssignment(t *testing.T) {
+    var label string
+    var verbose = true
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(
+        FlagOpt(&verbose, "verbose", 'V', "sets verbose"),
+        RequiredStringOpt(&label, "label", 'l', "sets label"))
+    myCli.AddCommands(
+        Command(cmdHandler, "greetings", "command description"))
+
+    err := myCli.Handle([]string{"--label=a=b", "--verbose=false", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if label != "a=b" || verbose {
+        t.Errorf("unexpected values: label=%q verbose=%v", label, verbose)
+    }
+
+    err = myCli.Handle([]string{"-l=c", "-V=true", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if label != "c" || !verbose {
+        t.Errorf("unexpected values: label=%q verbose=%v", label, verbose)
+    }
+}
+
+func TestShortOptionsCluster(t *testing.T) {
+    var label string
+    var verbose, force bool
+    var count *int64
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(
+        FlagOpt(&verbose, "verbose", 'V', "sets verbose"),
+        FlagOpt(&force, "force", 'f', "sets force"),
+        IntOpt(&count, "count", 'c', "sets count"),
+        RequiredStringOpt(&label, "label", 'l', "sets label"))
+    myCli.AddCommands(
+        Command(cmdHandler, "greetings", "command description"))
+
+    err := myCli.Handle([]string{"-Vfc5", "-lsir", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if !verbose || !force || count == nil || *count != 5 || label != "sir" {
+        t.Errorf("unexpected values: verbose=%v force=%v count=%v label=%q", verbose, force, count, label)
+    }
+
+    err = myCli.Handle([]string{"-Vfl", "madam", "greetings"})
+    if err != nil || label != "madam" {
+        t.Errorf("unexpected result: err=%v label=%q", err, label)
+    }
+
+    err = myCli.Handle([]string{"-Vxl", "madam", "greetings"})
+    if err == nil || !strings.Contains(err.Error(), "-x") {
+        t.Errorf("expected error naming -x, got %v", err)
+    }
+}
+
+func TestEndOfOptions(t *testing.T) {
+    var before, after []string
+    var force bool
+    myCli := New("my CLI", "x.y")
+    myCli.AddCommands(
+        PassthroughCommand(func(arguments []string, passthrough []string) error {
+            before, after = arguments, passthrough
+            return nil
+        }, "exec", "command description", FlagOpt(&force, "force", 'f', "sets force")))
+
+    err := myCli.Handle([]string{"exec", "-f", "ls", "--", "-la", "--", "/tmp"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if !force || strings.Join(before, " ") != "ls" || strings.Join(after, " ") != "-la -- /tmp" {
+        t.Errorf("unexpected values: force=%v before=%v after=%v", force, before, after)
+    }
+
+    force = false
+    err = myCli.Handle([]string{"--", "exec", "-f"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if force || len(before) != 0 || strings.Join(after, " ") != "-f" {
+        t.Errorf("unexpected values: force=%v before=%v after=%v", force, before, after)
+    }
+}
+
+func TestParsingModes(t *testing.T) {
+    var arguments []string
+    var force bool
+    handler := func(args []string) error {
+        arguments = args
+        return nil
+    }
+    myCli := New("my CLI", "x.y")
+    myCli.AddCommands(
+        Command(handler, "rm", "command description", FlagOpt(&force, "force", 'f', "sets force")))
+
+    err := myCli.Handle([]string{"rm", "a", "-f", "b"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if force || strings.Join(arguments, " ") != "a -f b" {
+        t.Errorf("unexpected values: force=%v arguments=%v", force, arguments)
+    }
+
+    myCli.SetParsingMode(GnuMode)
+    err = myCli.Handle([]string{"rm", "a", "-f", "b", "--", "-x"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if !force || strings.Join(arguments, " ") != "a b -x" {
+        t.Errorf("unexpected values: force=%v arguments=%v", force, arguments)
+    }
+
+    err = myCli.Handle([]string{"rm", "a", "-x"})
+    if err == nil {
+        t.Error("expected unknown option error")
+    }
+}
+
+func TestNegatableFlag(t *testing.T) {
+    var color bool
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(NegatableFlagOpt(&color, "color", 'c', "colorize output", true))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    for _, test := range []struct {
+        args     []string
+        expected bool
+    }{
+        {[]string{"--no-color", "greetings"}, false},
+        {[]string{"--no-color", "--color", "greetings"}, true},
+        {[]string{"-c", "--no-color", "greetings"}, false},
+        {[]string{"--no-color=false", "greetings"}, true},
+    } {
+        if err := myCli.Handle(test.args); err != nil {
+            t.Error(err.Error())
+        }
+        if color != test.expected {
+            t.Errorf("%v: expected %v, got %v", test.args, test.expected, color)
+        }
+    }
+}
+
+func TestSliceOptions(t *testing.T) {
+    var tags []string
+    var ports []int64
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(
+        StringSliceOpt(&tags, "tag", 't', "adds tag", "latest", "x,y"),
+        Separated(RequiredIntSliceOpt(&ports, "port", 'p', "adds port"), ":"))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    err := myCli.Handle([]string{"-p", "80", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if fmt.Sprint(tags) != "[latest x,y]" || len(ports) != 1 {
+        t.Errorf("unexpected values: tags=%v ports=%v", tags, ports)
+    }
+
+    err = myCli.Handle([]string{"--tag", "a", "-t", "b,c", "--port=80:443", "-p8080", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if strings.Join(tags, ",") != "a,b,c" || fmt.Sprint(ports) != "[80 443 8080]" {
+        t.Errorf("unexpected values: tags=%v ports=%v", tags, ports)
+    }
+
+    err = myCli.Handle([]string{"greetings"})
+    if err == nil {
+        t.Error("expected missing required option error")
+    }
+}
+
+func TestMapOptions(t *testing.T) {
+    var labels map[string]string
+    var limits map[string]interface{}
+    toInt := func(val string) (interface{}, error) {
+        return strconv.Atoi(val)
+    }
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(
+        UniqueKeys(StringMapOpt(&labels, "label", 'l', "adds label", "env=dev")),
+        MapOpt(&limits, toInt, "limit", 'L', "sets limit"))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    err := myCli.Handle([]string{"--label", "env=prod", "-l", "url=a=b", "-Lcpu=2", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if len(labels) != 2 || labels["env"] != "prod" || labels["url"] != "a=b" || limits["cpu"] != 2 {
+        t.Errorf("unexpected values: labels=%v limits=%v", labels, limits)
+    }
+
+    for _, args := range [][]string{
+        {"-l", "env=prod", "-l", "env=test", "greetings"},
+        {"-l", "env", "greetings"},
+        {"-L", "cpu=x", "greetings"},
+    } {
+        if err := myCli.Handle(args); err == nil {
+            t.Errorf("%v: expected error", args)
+        }
+    }
+}
+
+func TestCountOption(t *testing.T) {
+    var verbosity int
+    var force bool
+    myCli := Default("my CLI")
+    myCli.AddOptions(
+        CountOpt(&verbosity, "verbose", 'v', "increases verbosity"),
+        FlagOpt(&force, "force", 'f', "sets force"))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    for _, test := range []struct {
+        args     []string
+        expected int
+    }{
+        {[]string{"-v", "greetings"}, 1},
+        {[]string{"-vfvv", "greetings"}, 3},
+        {[]string{"--verbose", "-v", "--verbose", "greetings"}, 3},
+        {[]string{"-vv", "--verbose=5", "greetings"}, 5},
+    } {
+        if err := myCli.Handle(test.args); err != nil {
+            t.Error(err.Error())
+        }
+        if verbosity != test.expected {
+            t.Errorf("%v: expected %d, got %d", test.args, test.expected, verbosity)
+        }
+    }
+}
+
+func TestChoiceOption(t *testing.T) {
+    var format string
+    var output *string
+    formats := []string{"json", "yaml", "table"}
+    myCli := Default("my CLI",
+        RequiredChoiceOpt(&format, formats, "format", 'f', "output format"),
+        IgnoreCase(ChoiceOpt(&output, formats, "output", 'o', "output format")))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    err := myCli.Handle([]string{"-f", "yaml", "greetings"})
+    if err != nil || format != "yaml" {
+        t.Errorf("unexpected result: err=%v format=%q", err, format)
+    }
+
+    err = myCli.Handle([]string{"-f", "YAML", "greetings"})
+    if err == nil || !strings.Contains(err.Error(), "json, yaml, table") {
+        t.Errorf("expected error listing choices, got %v", err)
+    }
+
+    err = myCli.Handle([]string{"-f", "json", "-o", "TABLE", "greetings"})
+    if err != nil || output == nil || *output != "table" {
+        t.Errorf("unexpected result: err=%v output=%v", err, output)
+    }
+}
+
+func TestLongAndShortOnlyOptions(t *testing.T) {
+    var dryRun, force bool
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(
+        FlagOpt(&dryRun, "dry-run", NoShort, "sets dry run"),
+        FlagOpt(&force, NoLong, 'f', "sets force"))
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    if _, found := myCli.shortOptions()[shortPrefix+string(NoShort)]; found {
+        t.Error("long-only option registered a short name")
+    }
+    err := myCli.Handle([]string{"--dry-run", "-f", "greetings"})
+    if err != nil {
+        t.Error(err.Error())
+    }
+    if !dryRun || !force {
+        t.Errorf("unexpected values: dryRun=%v force=%v", dryRun, force)
+    }
+
+    for name, expected := range map[string]string{NoLong: "-x", "only-long": "--only-long"} {
+        short := byte('x')
+        if name != NoLong {
+            short = NoShort
+        }
+        func() {
+            defer func() {
+                if message := fmt.Sprint(recover()); message != "Choices for option "+expected+" can't be empty" {
+                    t.Errorf("unexpected panic %q", message)
+                }
+            }()
+            ChoiceOptFunc(func(string) error { return nil }, nil, name, short, "sets choice")
+        }()
+    }
+}
+
+func TestAbbreviations(t *testing.T) {
+    var verbose, version bool
+    var deployed bool
+    myCli := Default("my CLI")
+    myCli.AddOptions(
+        FlagOpt(&verbose, "verbose", 'V', "sets verbose"),
+        FlagOpt(&version, "verbatim", 'b', "sets verbatim"),
+        NegatableFlagOpt(&deployed, "deployed", 'd', "sets deployed", true))
+    myCli.AddCommands(
+        Command(cmdHandler, "deploy", "command description"),
+        Command(cmdHandler, "delete", "command description"))
+
+    if err := myCli.Handle([]string{"--verbo", "deploy"}); err == nil {
+        t.Error("expected unknown argument error")
+    }
+    myCli.SetAbbreviations(true)
+    if err := myCli.Handle([]string{"--verbo", "--no-dep", "dep"}); err != nil {
+        t.Error(err.Error())
+    }
+    if !verbose || deployed {
+        t.Errorf("unexpected values: verbose=%v deployed=%v", verbose, deployed)
+    }
+    err := myCli.Handle([]string{"--verb", "deploy"})
+    if err == nil || !strings.Contains(err.Error(), "--verbatim, --verbose") {
+        t.Errorf("expected ambiguous option error, got %v", err)
+    }
+    err = myCli.Handle([]string{"de"})
+    if err == nil || !strings.Contains(err.Error(), "delete, deploy") {
+        t.Errorf("expected ambiguous command error, got %v", err)
+    }
+}
+
+func TestAliases(t *testing.T) {
+    var dryRun bool
+    var removed []string
+    myCli := New("my CLI", "x.y")
+    myCli.AddOptions(Aliases(FlagOpt(&dryRun, "dry-run", 'n', "sets dry run"), "dryrun", "d"))
+    myCli.AddCommands(Command(func(args []string) error {
+        removed = args
+        return nil
+    }, "remove", "command description").AddAliases("rm"))
+
+    for _, args := range [][]string{
+        {"--dryrun", "rm", "a"},
+        {"-d", "remove", "a"},
+    } {
+        removed = nil
+        if err := myCli.Handle(args); err != nil {
+            t.Error(err.Error())
+        }
+        if !dryRun || len(removed) != 1 {
+            t.Errorf("%v: unexpected values: dryRun=%v removed=%v", args, dryRun, removed)
+        }
+    }
+
+    // aliases added after the command is attached are registered too
+    list := Command(cmdHandler, "list", "command description")
+    myCli.AddCommands(list)
+    list.AddAliases("ls")
+    if err := myCli.Handle([]string{"ls"}); err != nil {
+        t.Error(err.Error())
+    }
+
+    defer func() {
+        if recover() == nil {
+            t.Error("expected duplicate alias panic")
+        }
+    }()
+    myCli.AddCommands(Command(cmdHandler, "rm", "command description"))
+}
+
+// returns what usage of the level prints to standard output
+func captureUsage(t *testing.T, level cmdInfo) string {
+    reader, writer, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    stdout := os.Stdout
+    os.Stdout = writer
+    level.Usage()
+    os.Stdout = stdout
+    writer.Close()
+    output, err := ioutil.ReadAll(reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(output)
+}
+
+func TestHiddenItems(t *testing.T) {
+    var secret, called bool
+    myCli := Default("my CLI", FlagOpt(&secret, "public", NoShort, "sets public"), Hidden(FlagOpt(&secret, "secret", NoShort, "sets secret")))
+    myCli.AddCommands(Command(func([]string) error {
+        called = true
+        return nil
+    }, "internal", "command description").Hide())
+    myCli.AddGroups(Group("debug", "group description", Command(cmdHandler, "dump", "command description")).Hide())
+
+    hiddenItems := []string{"--secret", "internal", "debug"}
+    os.Unsetenv(ShowHiddenEnv)
+    usage := captureUsage(t, myCli)
+    if !strings.Contains(usage, "--public") {
+        t.Errorf("expected --public in usage:\n%s", usage)
+    }
+    for _, item := range hiddenItems {
+        if strings.Contains(usage, item) {
+            t.Errorf("unexpected %s in usage:\n%s", item, usage)
+        }
+    }
+
+    os.Setenv(ShowHiddenEnv, "true")
+    usage = captureUsage(t, myCli)
+    os.Unsetenv(ShowHiddenEnv)
+    for _, item := range hiddenItems {
+        if !strings.Contains(usage, item) {
+            t.Errorf("expected %s in usage:\n%s", item, usage)
+        }
+    }
+
+    if err := myCli.Handle([]string{"--secret", "internal"}); err != nil || !secret || !called {
+        t.Errorf("unexpected result: err=%v secret=%v called=%v", err, secret, called)
+    }
+    if err := myCli.Handle([]string{"debug", "dump"}); err != nil {
+        t.Error(err.Error())
+    }
+}
+
+func TestDeprecations(t *testing.T) {
+    var dryRun bool
+    myCli := Default("my CLI")
+    myCli.AddOptions(Deprecated(FlagOpt(&dryRun, "dryrun", NoShort, "sets dry run"), "renamed", "--dry-run"))
+    myCli.AddCommands(Command(cmdHandler, "remove", "command description").Deprecate("", "delete"))
+
+    if err := myCli.Handle([]string{"--dryrun", "remove"}); err != nil {
+        t.Error(err.Error())
+    }
+    if !dryRun {
+        t.Error("deprecated option was not applied")
+    }
+
+    myCli.SetStrictDeprecations(true)
+    err := myCli.Handle([]string{"--dryrun", "remove"})
+    if err == nil || !strings.Contains(err.Error(), "use --dry-run instead") {
+        t.Errorf("expected deprecation error, got %v", err)
+    }
+    err = myCli.Handle([]string{"remove"})
+    if err == nil || !strings.Contains(err.Error(), "use delete instead") {
+        t.Errorf("expected deprecation error, got %v", err)
+    }
+}
+
+func TestEnvironment(t *testing.T) {
+    var user, token string
+    var tags []string
+    myCli := Default("my CLI",
+        RequiredStringOpt(&user, "user", 'u', "sets user", "nobody"),
+        Env(RequiredStringOpt(&token, "token", NoShort, "sets token"), "API_TOKEN"),
+        StringSliceOpt(&tags, "tag", 't', "adds tag"))
+    myCli.SetEnvPrefix("MYCLI")
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+    os.Setenv("MYCLI_USER", "env-user")
+    os.Setenv("API_TOKEN", "secret")
+    os.Setenv("MYCLI_TAG", "a,b")
+    defer os.Unsetenv("MYCLI_USER")
+    defer os.Unsetenv("API_TOKEN")
+    defer os.Unsetenv("MYCLI_TAG")
+
+    if err := myCli.Handle([]string{"greetings"}); err != nil {
+        t.Error(err.Error())
+    }
+    if user != "env-user" || token != "secret" || strings.Join(tags, ",") != "a,b" {
+        t.Errorf("unexpected values: user=%q token=%q tags=%v", user, token, tags)
+    }
+
+    if err := myCli.Handle([]string{"-u", "cli-user", "-t", "c", "greetings"}); err != nil {
+        t.Error(err.Error())
+    }
+    if user != "cli-user" || strings.Join(tags, ",") != "c" {
+        t.Errorf("unexpected values: user=%q tags=%v", user, tags)
+    }
+}
+
+func TestConfigFile(t *testing.T) {
+    configs := map[string]string{
+        "config.json": `{"user": "json", "tag": ["a", "b"], "deploy": {"force": true, "label": {"env": "prod"}}}`,
+        "config.yaml": "user: yaml # comment\ntag:\n  - a\n  - b\ndeploy:\n  force: true\n  label: {env: prod}\n",
+        "config.toml": "user = \"toml\"\ntag = [\"a\", \"b\"]\n\n[deploy]\nforce = true\nlabel = { env = \"prod\" }\n",
+        "config.ini":  "user = ini\ntag = a\ntag = b\n\n[deploy]\nforce = true\nlabel = env=prod\n",
+    }
+    dir, err := ioutil.TempDir("", "go-cli")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    for file, content := range configs {
+        path := filepath.Join(dir, file)
+        if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+        var user string
+        var tags []string
+        var force bool
+        var labels map[string]string
+        myCli := Default("my CLI",
+            RequiredStringOpt(&user, "user", 'u', "sets user"),
+            StringSliceOpt(&tags, "tag", 't', "adds tag"))
+        myCli.AddConfig(filepath.Join(dir, "missing.json"), path)
+        myCli.AddCommands(Command(cmdHandler, "deploy", "command description",
+            FlagOpt(&force, "force", 'f', "sets force"),
+            StringMapOpt(&labels, "label", 'l', "adds label")))
+
+        if err := myCli.Handle([]string{"deploy"}); err != nil {
+            t.Errorf("%s: %s", file, err)
+        }
+        expected := strings.TrimPrefix(file, "config.")
+        if user != expected || strings.Join(tags, ",") != "a,b" || !force || labels["env"] != "prod" {
+            t.Errorf("%s: unexpected values: user=%q tags=%v force=%v labels=%v", file, user, tags, force, labels)
+        }
+    }
+
+    var user string
+    path := filepath.Join(dir, "other.json")
+    ioutil.WriteFile(path, []byte(`{"user": "other", "unknown": 1}`), 0644)
+    myCli := Default("my CLI", RequiredStringOpt(&user, "user", 'u', "sets user")).AddConfig()
+    myCli.AddCommands(Command(cmdHandler, "deploy", "command description"))
+    err = myCli.Handle([]string{"--config", path, "deploy"})
+    if err == nil || !strings.Contains(err.Error(), "unknown") {
+        t.Errorf("expected unknown option error, got %v", err)
+    }
+    ioutil.WriteFile(path, []byte(`{"user": "other"}`), 0644)
+    if err := myCli.Handle([]string{"--config=" + path, "-u", "cli", "deploy"}); err != nil || user != "cli" {
+        t.Errorf("unexpected result: err=%v user=%q", err, user)
+    }
+    myCli.SetAbbreviations(true)
+    if err := myCli.Handle([]string{"--conf", path, "deploy"}); err != nil || user != "other" {
+        t.Errorf("unexpected result: err=%v user=%q", err, user)
+    }
+
+    // null values are skipped, persistent options can be set in sections of sub-commands
+    var verbose bool
+    var nick *string
+    sectionCli := Default("my CLI", Persistent(FlagOpt(&verbose, "verbose", 'V', "sets verbose")), StringOpt(&nick, "nick", NoShort, "sets nick"))
+    sectionCli.AddConfig().AddCommands(Command(cmdHandler, "run", "command description"))
+    yamlPath := filepath.Join(dir, "section.yaml")
+    ioutil.WriteFile(yamlPath, []byte("nick: null\nrun:\n  verbose: true\n"), 0644)
+    if err := sectionCli.Handle([]string{"--config", yamlPath, "run"}); err != nil || !verbose || nick != nil {
+        t.Errorf("unexpected result: err=%v verbose=%v nick=%v", err, verbose, nick)
+    }
+
+    // --config of a wrapped tool is not ours
+    kube := filepath.Join(dir, "kube.yaml")
+    ioutil.WriteFile(kube, []byte("apiVersion: v1\n"), 0644)
+    var wrapped []string
+    myCli.AddCommands(CommandWithoutHelp(func(args []string) error {
+        wrapped = args
+        return nil
+    }, "exec", "command description"))
+    if err := myCli.Handle([]string{"-u", "cli", "exec", "kubectl", "--config", kube, "get"}); err != nil || len(wrapped) != 4 {
+        t.Errorf("unexpected result: err=%v wrapped=%v", err, wrapped)
+    }
+}
+
+func TestConfigDecoders(t *testing.T) {
+    config, err := decodeToml([]byte("tag = [\n  \"a\", # first\n  \"b\",\n]\n\n[[server]]\nname = \"x\"\n\n[[server]]\nname = \"y\"\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprint(config["tag"]) != "[a b]" || fmt.Sprint(config["server"]) != "[map[name:x] map[name:y]]" {
+        t.Errorf("unexpected toml config: %v", config)
+    }
+    config, err = decodeYaml([]byte("server:\n  - name: x\n    port: 1\n  - name: y\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fmt.Sprint(config["server"]) != "[map[name:x port:1] map[name:y]]" {
+        t.Errorf("unexpected yaml config: %v", config)
+    }
+
+    for _, unsupported := range []string{"desc = \"\"\"\ntext\"\"\"", "tag = [\"a\","} {
+        if _, err := decodeToml([]byte(unsupported)); err == nil {
+            t.Errorf("%q: expected error", unsupported)
+        }
+    }
+    for _, unsupported := range []string{"desc: |\n  text", "a: &x 1\nb: *x", "a: !!str 1", "a: 1\n---\nb: 2"} {
+        if _, err := decodeYaml([]byte(unsupported)); err == nil {
+            t.Errorf("%q: expected error", unsupported)
+        }
+    }
+}
+
+func TestValueSources(t *testing.T) {
+    dir, err := ioutil.TempDir("", "go-cli")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "config.json")
+    ioutil.WriteFile(path, []byte(`{"a": "config", "b": "config", "c": "config"}`), 0644)
+    os.Setenv("SRC_B", "env")
+    os.Setenv("SRC_C", "env")
+    defer os.Unsetenv("SRC_B")
+    defer os.Unsetenv("SRC_C")
+
+    var a, b, c, d string
+    options := []*Option{
+        RequiredStringOpt(&a, "a", NoShort, "a", "default"),
+        RequiredStringOpt(&b, "b", NoShort, "b", "default"),
+        RequiredStringOpt(&c, "c", NoShort, "c", "default"),
+        RequiredStringOpt(&d, "d", NoShort, "d", "default"),
+    }
+    myCli := Default("my CLI", options...).SetEnvPrefix("SRC").AddConfig(path)
+    myCli.AddCommands(Command(cmdHandler, "greetings", "command description"))
+
+    if err := myCli.Handle([]string{"--c", "cli", "greetings"}); err != nil {
+        t.Error(err.Error())
+    }
+    sources := []Source{ConfigFile, Environment, CommandLine, DefaultValue}
+    expected := []string{"config", "env", "cli", "default"}
+    for index, value := range []string{a, b, c, d} {
+        if options[index].Source() != sources[index] || value != expected[index] {
+            t.Errorf("%s: unexpected value %q from %s", options[index].name(), value, options[index].Source())
+        }
+    }
+
+    var user string
+    called := false
+    printCli := Default("my CLI").AddPrintConfig().AddCommands(Command(func([]string) error {
+        called = true
+        return nil
+    }, "ls", "command description", RequiredStringOpt(&user, "user", 'u', "sets user")))
+    for _, args := range [][]string{{"--print-config", "ls"}, {"ls", "-u", "sir", "--print-config"}} {
+        if err := printCli.Handle(args); err != nil || called {
+            t.Errorf("%v: unexpected result: err=%v called=%v", args, err, called)
+        }
+    }
+}
+
+func TestPersistentOptions(t *testing.T) {
+    var verbose bool
+    var user string
+    myCli := New("my CLI", "x.y", Persistent(FlagOpt(&verbose, "verbose", 'V', "sets verbose")))
+    myCli.AddGroups(Group("deploy", "group description",
+        Command(cmdHandler, "app", "command description", RequiredStringOpt(&user, "user", 'u', "sets user"))))
+
+    if err := myCli.Handle([]string{"deploy", "app", "-Vu", "sir"}); err != nil {
+        t.Error(err.Error())
+    }
+    if !verbose || user != "sir" {
+        t.Errorf("unexpected values: verbose=%v user=%q", verbose, user)
+    }
+
+    func() {
+        defer func() {
+            if recover() == nil {
+                t.Error("expected duplicate option panic")
+            }
+        }()
+        myCli.AddCommands(Command(cmdHandler, "other", "command description", FlagOpt(&verbose, "verbose", NoShort, "sets verbose")))
+    }()
+    func() {
+        defer func() {
+            if recover() == nil {
+                t.Error("expected duplicate option panic")
+            }
+        }()
+        myCli.AddOptions(Persistent(FlagOpt(&verbose, "user", NoShort, "sets user")))
+    }()
+
+    // non-persistent options of the parent don't clash, regardless of the order of calls
+    var nick *string
+    late := CommandWithoutHelp(cmdHandler, "late", "command description")
+    myCli.AddCommands(late)
+    late.AddHelp().AddOptions(StringOpt(&nick, "version", NoShort, "sets version"))
+
+    var token string
+    var json, table bool
+    jsonOpt := Persistent(FlagOpt(&json, "json", NoShort, "sets json output"))
+    tableOpt := Persistent(FlagOpt(&table, "table", NoShort, "sets table output"))
+    otherCli := Default("my CLI", Persistent(RequiredStringOpt(&token, "token", NoShort, "sets token")), jsonOpt, tableOpt).
+        AddExclusive(jsonOpt, tableOpt).
+        AddCommands(Command(cmdHandler, "ls", "command description"))
+    if err := otherCli.Handle([]string{"ls", "--token", "abc", "--json"}); err != nil || token != "abc" {
+        t.Errorf("unexpected result: err=%v token=%q", err, token)
+    }
+    if err := otherCli.Handle([]string{"ls", "--token", "abc", "--json", "--table"}); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+        t.Errorf("expected mutually exclusive error, got %v", err)
+    }
+    if err := otherCli.Handle([]string{"ls"}); err == nil || !strings.Contains(err.Error(), "--token") {
+        t.Errorf("expected missing --token error, got %v", err)
+    }
+}
+
+func TestTypedArguments(t *testing.T) {
+    var count int64
+    var timeout time.Duration
+    var format string
+    var files []string
+    command := Command(cmdHandler, "copy", "command description").AddArguments(
+        Mandatory(IntArg(&count, "count", "number of copies")),
+        DurationArg(&timeout, "timeout", "copy timeout"),
+        ChoiceArg(&format, []string{"raw", "zip"}, "format", "output format"),
+        StringsArg(&files, "files", "files to copy"))
+    myCli := Default("my CLI").AddCommands(command)
+
+    if err := myCli.Handle([]string{"copy", "3", "1m", "zip", "a", "--", "-b"}); err != nil {
+        t.Error(err.Error())
+    }
+    if count != 3 || timeout != time.Minute || format != "zip" || strings.Join(files, " ") != "a -b" {
+        t.Errorf("unexpected values: count=%d timeout=%v format=%q files=%v", count, timeout, format, files)
+    }
+
+    for args, expected := range map[string]string{
+        "copy":        "<count>",
+        "copy x":      "<count>",
+        "copy 1 1m x": "[format]",
+    } {
+        err := myCli.Handle(strings.Fields(args))
+        if err == nil || !strings.Contains(err.Error(), expected) {
+            t.Errorf("%s: expected error naming %s, got %v", args, expected, err)
+        }
+    }
+
+    strict := Command(cmdHandler, "move", "command description").AddArguments(Mandatory(Argument("file")))
+    myCli.AddCommands(strict)
+    if err := myCli.Handle([]string{"move", "a", "b"}); err == nil {
+        t.Error("expected unexpected argument error")
+    }
+}
+
+func TestArgumentCount(t *testing.T) {
+    myCli := Default("my CLI").AddCommands(
+        Command(cmdHandler, "none", "command description").ExpectArgs(NoArgs()),
+        Command(cmdHandler, "pair", "command description").ExpectArgs(ExactArgs(2)),
+        Command(cmdHandler, "some", "command description").ExpectArgs(RangeArgs(1, 2)))
+
+    for args, valid := range map[string]bool{
+        "none":       true,
+        "none a":     false,
+        "pair a b":   true,
+        "pair a":     false,
+        "some":       false,
+        "some a b":   true,
+        "some a b c": false,
+    } {
+        err := myCli.Handle(strings.Fields(args))
+        if valid && err != nil {
+            t.Errorf("%s: %s", args, err)
+        }
+        if !valid && (err == nil || !strings.Contains(err.Error(), "Usage: ")) {
+            t.Errorf("%s: expected error with usage, got %v", args, err)
+        }
+    }
+
+    var file string
+    list := Command(cmdHandler, "list", "command description").AddArguments(Mandatory(StringArg(&file, "file", "sets file"))).ExpectArgs(RangeArgs(1, 2))
+    if line := usageLine(list); line != "list [OPTIONS] <file> [ARG]" {
+        t.Errorf("unexpected usage line %q", line)
+    }
+
+    for name, count := range map[string]func(){
+        "exact": func() { ExactArgs(-1) },
+        "min":   func() { MinArgs(-1) },
+        "max":   func() { MaxArgs(-1) },
+        "range": func() { RangeArgs(2, 1) },
+    } {
+        func() {
+            defer func() {
+                if recover() == nil {
+                    t.Errorf("%s: expected panic for invalid count", name)
+                }
+            }()
+            count()
+        }()
+    }
+}
+
+func TestExclusiveOptions(t *testing.T) {
+    var json, yaml, table bool
+    jsonOpt := FlagOpt(&json, "json", NoShort, "sets json output")
+    yamlOpt := FlagOpt(&yaml, "yaml", NoShort, "sets yaml output")
+    tableOpt := NegatableFlagOpt(&table, "table", NoShort, "sets table output", true)
+    myCli := Default("my CLI").AddCommands(
+        Command(cmdHandler, "list", "command description", jsonOpt, yamlOpt, tableOpt).AddExclusive(jsonOpt, yamlOpt, tableOpt))
+
+    for args, valid := range map[string]bool{
+        "list":                true,
+        "list --json":         true,
+        "list --json --yaml":  false,
+        "list --yaml --table": false,
+    } {
+        err := myCli.Handle(strings.Fields(args))
+        if valid && err != nil {
+            t.Errorf("%s: %s", args, err)
+        }
+        if !valid && (err == nil || !strings.Contains(err.Error(), "mutually exclusive")) {
+            t.Errorf("%s: expected mutually exclusive error, got %v", args, err)
+        }
+    }
+
+    defer func() {
+        if recover() == nil {
+            t.Error("expected panic for unknown exclusive option")
+        }
+    }()
+    var other bool
+    Default("my CLI").AddExclusive(FlagOpt(&other, "one", NoShort, "sets one"), FlagOpt(&other, "two", NoShort, "sets two"))
+}
+
+func TestConstraints(t *testing.T) {
+    var key, cert, user, password, name *string
+    var id *int64
+    keyOpt := StringOpt(&key, "tls-key", NoShort, "sets key")
+    // defaults don't satisfy constraints
+    certOpt := StringOpt(&cert, "tls-cert", NoShort, "sets certificate", "cert.pem")
+    userOpt := StringOpt(&user, "user", NoShort, "sets user")
+    passwordOpt := StringOpt(&password, "password", NoShort, "sets password")
+    idOpt := IntOpt(&id, "id", NoShort, "sets id")
+    nameOpt := StringOpt(&name, "name", NoShort, "sets name", "nobody")
+    myCli := Default("my CLI", keyOpt, certOpt, userOpt, passwordOpt).
+        AddConstraints(Requires(keyOpt, certOpt), RequiredIf(passwordOpt, userOpt)).
+        AddCommands(Command(cmdHandler, "get", "command description", idOpt, nameOpt).
+            AddConstraints(AtLeastOneOf(idOpt, nameOpt)))
+
+    for args, violations := range map[string][]string{
+        "get --id 1":                            nil,
+        "--tls-key k --tls-cert c get --name n": nil,
+        "--user u --password p get --id 1":      nil,
+        "--tls-key k get --id 1":                {"--tls-key requires --tls-cert"},
+        "--user u get --id 1":                   {"--password is required if --user is given"},
+        "--tls-key k --user u get":              {"--tls-key requires --tls-cert", "--password is required if --user is given"},
+        "get":                                   {"at least one of options --id, --name is required"},
+    } {
+        err := myCli.Handle(strings.Fields(args))
+        if len(violations) == 0 && err != nil {
+            t.Errorf("%s: %s", args, err)
+        }
+        if len(violations) > 0 && err == nil {
+            t.Errorf("%s: expected error", args)
+            continue
+        }
+        for _, violation := range violations {
+            if !strings.Contains(err.Error(), violation) {
+                t.Errorf("%s: expected %q in error %q", args, violation, err)
+            }
+        }
+    }
+
+    usage := captureUsage(t, myCli)
+    for _, constraint := range []string{"--tls-key requires --tls-cert", "--password is required if --user is given"} {
+        if !strings.Contains(usage, constraint) {
+            t.Errorf("expected %q in usage:\n%s", constraint, usage)
+        }
+    }
+}
+
+func TestValidators(t *testing.T) {
+    var port, workers *int64
+    var ratio *float64
+    var timeout *time.Duration
+    var name, tag *string
+    myCli := Default("my CLI").AddCommands(
+        Command(cmdHandler, "serve", "command description",
+            Validate(IntOpt(&port, "port", 'p', "sets port", 8080), IntRange(1, 65535)),
+            Validate(FloatOpt(&ratio, "ratio", NoShort, "sets ratio"), FloatRange(0, 1)),
+            Validate(DurationOpt(&timeout, "timeout", NoShort, "sets timeout"), DurationRange(time.Second, time.Minute)),
+            Validate(StringOpt(&name, "name", NoShort, "sets name"), NotEmpty(), Matches("^[a-z]+$")),
+            Validate(StringOpt(&tag, "tag", NoShort, "sets tag"), func(val string) error {
+                if strings.HasPrefix(val, "v") {
+                    return nil
+                }
+                return fmt.Errorf("must start with v")
+            })),
+        Command(cmdHandler, "work", "command description",
+            Validate(IntOpt(&workers, "workers", 'w', "sets workers", 0), IntRange(1, 16))))
+
+    if err := myCli.Handle(strings.Fields("serve --ratio 0.5 --timeout 5s --name web --tag v1")); err != nil {
+        t.Error(err.Error())
+    }
+    if err := myCli.Handle([]string{"work"}); err == nil || !strings.Contains(err.Error(), `--workers value "0"`) {
+        t.Errorf("expected invalid default error, got %v", err)
+    }
+    for args, invalid := range map[string]string{
+        "serve -p 70000":     `-p value "70000"`,
+        "serve --ratio 2":    `--ratio value "2"`,
+        "serve --timeout 2h": `--timeout value "2h"`,
+        "serve --name Web":   `--name value "Web"`,
+        "serve --name=":      `--name value ""`,
+        "serve --tag 1":      `--tag value "1"`,
+    } {
+        err := myCli.Handle(strings.Fields(args))
+        if err == nil || !strings.Contains(err.Error(), invalid) {
+            t.Errorf("%s: expected error containing %s, got %v", args, invalid, err)
+        }
+    }
+
+    var verbosity int
+    countCli := Default("my CLI", Validate(CountOpt(&verbosity, "verbose", 'V', "sets verbosity"), IntRange(0, 2))).
+        AddCommands(Command(cmdHandler, "serve", "command description"))
+    if err := countCli.Handle([]string{"-VV", "serve"}); err != nil || verbosity != 2 {
+        t.Errorf("unexpected result: err=%v verbosity=%d", err, verbosity)
+    }
+    if err := countCli.Handle([]string{"-VVV", "serve"}); err == nil || !strings.Contains(err.Error(), "Invalid -V value: count 3") {
+        t.Errorf("expected count error, got %v", err)
+    }
+
+    defer func() {
+        if recover() == nil {
+            t.Error("expected panic for validated flag")
+        }
+    }()
+    var force bool
+    Validate(FlagOpt(&force, "force", 'f', "sets force"), NotEmpty())
+}
+
+func TestPathOptions(t *testing.T) {
+    dir, err := ioutil.TempDir("", "cli")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer os.RemoveAll(dir)
+    for _, name := range []string{"a.txt", "b.txt"} {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+            t.Fatal(err.Error())
+        }
+    }
+    os.Setenv("CLI_TEST_DIR", dir)
+    defer os.Unsetenv("CLI_TEST_DIR")
+
+    var input, output, workdir *string
+    var files []string
+    myCli := Default("my CLI").AddCommands(Command(cmdHandler, "copy", "command description",
+        Validate(Transform(PathOpt(&input, "input", 'i', "sets input"), ExpandPath()), IsFile(), Readable()),
+        Validate(Transform(PathOpt(&output, "output", 'o', "sets output"), ExpandPath(), AbsolutePath()), Writable()),
+        Validate(PathOpt(&workdir, "workdir", NoShort, "sets working directory"), IsDir()),
+        Validate(Transform(PathSliceOpt(&files, "files", 'f', "sets files"), ExpandPath(), Glob()), PathExists())))
+
+    args := []string{"copy", "-i", "$CLI_TEST_DIR/a.txt", "-o", filepath.Join(dir, "out.txt"), "--workdir", dir, "-f", "${CLI_TEST_DIR}/*.txt"}
+    if err := myCli.Handle(args); err != nil {
+        t.Fatal(err.Error())
+    }
+    if *input != filepath.Join(dir, "a.txt") || !filepath.IsAbs(*output) || *workdir != dir {
+        t.Errorf("unexpected paths: input=%q output=%q workdir=%q", *input, *output, *workdir)
+    }
+    if len(files) != 2 || files[0] != filepath.Join(dir, "a.txt") || files[1] != filepath.Join(dir, "b.txt") {
+        t.Errorf("unexpected files: %v", files)
+    }
+
+    for _, args := range [][]string{
+        {"copy", "-i", filepath.Join(dir, "missing.txt")},
+        {"copy", "--input", dir},
+        {"copy", "--workdir", filepath.Join(dir, "a.txt")},
+        {"copy", "-o", filepath.Join(dir, "missing", "out.txt")},
+        {"copy", "-f", filepath.Join(dir, "*.csv")},
+        {"copy", "-i", filepath.Join(dir, "*.txt")},
+    } {
+        err := myCli.Handle(args)
+        if err == nil || !strings.Contains(err.Error(), args[1]) {
+            t.Errorf("%v: expected error naming %s, got %v", args, args[1], err)
+        }
+    }
+}
+
+func TestRepeatedHandle(t *testing.T) {
+    var user string
+    var verbosity int
+    var level *int64
+    var tags, files []string
+    var labels map[string]string
+    myCli := Default("my CLI", CountOpt(&verbosity, "verbose", 'V', "sets verbosity")).AddCommands(
+        Command(cmdHandler, "run", "command description",
+            RequiredStringOpt(&user, "user", 'u', "sets user"),
+            IntOpt(&level, "level", 'l', "sets level", 3),
+            StringSliceOpt(&tags, "tag", 't', "sets tags"),
+            StringMapOpt(&labels, "label", NoShort, "sets labels")).
+            AddArguments(StringsArg(&files, "files", "sets files")))
+
+    if err := myCli.Handle(strings.Fields("-VV run -u sir -l 5 -t a,b --label k=v x y")); err != nil {
+        t.Fatal(err.Error())
+    }
+    if verbosity != 2 || user != "sir" || *level != 5 || len(tags) != 2 || labels["k"] != "v" || len(files) != 2 {
+        t.Errorf("unexpected values: %d %q %d %v %v %v", verbosity, user, *level, tags, labels, files)
+    }
+
+    if err := myCli.Handle(strings.Fields("-V run -u man -t c z")); err != nil {
+        t.Fatal(err.Error())
+    }
+    if verbosity != 1 || user != "man" || *level != 3 || len(tags) != 1 || len(labels) != 0 || len(files) != 1 {
+        t.Errorf("unexpected values: %d %q %d %v %v %v", verbosity, user, *level, tags, labels, files)
+    }
+
+    if err := myCli.Handle([]string{"run"}); err == nil || !strings.Contains(err.Error(), "missing required options") {
+        t.Errorf("expected missing required option, got %v", err)
+    }
+    if verbosity != 0 || user != "" || len(tags) != 0 || len(files) != 0 {
+        t.Errorf("expected values to be restored: %d %q %v %v", verbosity, user, tags, files)
+    }
+
+    defer func() {
+        if message := fmt.Sprint(recover()); message != "Value for option --user|-u can't be nil" {
+            t.Errorf("unexpected panic %q", message)
+        }
+    }()
+    RequiredStringOpt((*string)(nil), "user", 'u', "sets user")
+}
@@ -8,8 +8,38 @@ import (
     "os"
     "unicode"
     "regexp"
+    "sort"
+    "strconv"
+    "reflect"
 )
 
+// replacement is optional
+type deprecation struct {
+    message     string
+    replacement string
+}
+
+func (d *deprecation) String() string {
+    text := "deprecated"
+    if d.message != "" {
+        text += ": " + d.message
+    }
+    if d.replacement != "" {
+        text += ", use " + d.replacement + " instead"
+    }
+    return text
+}
+
+func deprecated(description string, deprecation *deprecation) string {
+    if deprecation == nil {
+        return description
+    }
+    return fmt.Sprintf("%s (%s)", description, deprecation)
+}
+
+// set to true to list hidden options, commands and groups in help
+const ShowHiddenEnv = "CLI_SHOW_HIDDEN"
+
 type cmdInfo interface {
     trigger() string
     description() string
@@ -18,34 +48,161 @@ type cmdInfo interface {
     groups() map[string]*Grp
     commands() map[string]*Cmd
     arguments() []*Arg
+    optionConstraints() []*Constraint
+    parent() cmdInfo
+    setParent(parent cmdInfo)
     Usage()
 }
 
+func root(cli cmdInfo) cmdInfo {
+    for cli.parent() != nil {
+        cli = cli.parent()
+    }
+    return cli
+}
+
+func envPrefix(cli cmdInfo) string {
+    if root, ok := root(cli).(*Cli); ok {
+        return root.envPrefix
+    }
+    return ""
+}
+
 func addHelp(cli cmdInfo) cmdInfo {
-    return addOptions(cli, FlagOptFunc(func() error {
+    return addOptions(cli, builtin(FlagOptFunc(func() error {
         cli.Usage()
         os.Exit(0)
         return nil
-    }, "help", 'h', "Show help and exit"))
+    }, "help", 'h', "Show help and exit")))
 }
 
 func addOptions(cli cmdInfo, options ...*Option) cmdInfo {
     for _, option := range options {
         if option != nil {
-            checkDuplicates(cli, option.long)
-            cli.options()[option.long] = option
-            checkDuplicates(cli, option.short)
-            cli.shortOptions()[option.short] = option
+            if option.long != "" {
+                checkDuplicates(cli, option.long)
+                cli.options()[option.long] = option
+                if option.negatable {
+                    checkDuplicates(cli, option.negation())
+                    cli.options()[option.negation()] = option
+                }
+            }
+            if option.short != "" {
+                checkDuplicates(cli, option.short)
+                cli.shortOptions()[option.short] = option
+            }
+            for _, alias := range option.longAliases() {
+                checkDuplicates(cli, alias)
+                cli.options()[alias] = option
+            }
+            for _, alias := range option.shortAliases() {
+                checkDuplicates(cli, alias)
+                cli.shortOptions()[alias] = option
+            }
+            if option.persistent {
+                for _, group := range uniqueGroups(cli) {
+                    checkInherited(group, option)
+                }
+                for _, command := range uniqueCommands(cli) {
+                    checkInherited(command, option)
+                }
+            }
         }
     }
     return cli
 }
 
+// persistent options of the level and its ancestors
+func persistentOptions(cli cmdInfo) []*Option {
+    var options []*Option
+    for level := cli; level != nil; level = level.parent() {
+        for _, option := range uniqueOptions(level) {
+            if option.persistent {
+                options = append(options, option)
+            }
+        }
+    }
+    return options
+}
+
+// options registered on the level together with persistent options of its ancestors
+func availableOptions(cli cmdInfo, short bool) map[string]*Option {
+    options := make(map[string]*Option)
+    for level := cli; level != nil; level = level.parent() {
+        registered := level.options()
+        if short {
+            registered = level.shortOptions()
+        }
+        for name, option := range registered {
+            if _, exists := options[name]; !exists && (level == cli || option.persistent) {
+                options[name] = option
+            }
+        }
+    }
+    return options
+}
+
+func walk(cli cmdInfo, visit func(cmdInfo)) {
+    visit(cli)
+    for _, group := range uniqueGroups(cli) {
+        walk(group, visit)
+    }
+    for _, command := range uniqueCommands(cli) {
+        walk(command, visit)
+    }
+}
+
+// forgets state of the previous parsing in the whole tree
+func resetState(cli cmdInfo) {
+    walk(cli, func(level cmdInfo) {
+        for _, option := range uniqueOptions(level) {
+            option.reset()
+        }
+        for _, arg := range level.arguments() {
+            arg.reset()
+        }
+    })
+}
+
+// bound variable is restored to its initial value before each parsing,
+// returns function setting the pointed variable back to its current value
+func restorer(pointer interface{}, owner string) func() {
+    value := reflect.ValueOf(pointer)
+    if !value.IsValid() || value.Kind() != reflect.Ptr || value.IsNil() {
+        panic("Value for " + owner + " can't be nil")
+    }
+    target := value.Elem()
+    initial := reflect.New(target.Type()).Elem()
+    initial.Set(target)
+    return func() {
+        target.Set(initial)
+    }
+}
+
+// inherited options can't be redeclared lower in the tree
+func checkInherited(subtree cmdInfo, options ...*Option) {
+    walk(subtree, func(level cmdInfo) {
+        for _, option := range options {
+            for _, name := range option.names() {
+                _, long := level.options()[name]
+                _, short := level.shortOptions()[name]
+                if long || short {
+                    panic(fmt.Sprintf("Duplicit option %s", name))
+                }
+            }
+        }
+    })
+}
+
 func addGroups(cli cmdInfo, categories ...*Grp) cmdInfo {
     for _, group := range categories {
         if group != nil {
-            checkDuplicates(cli, group.name)
-            cli.groups()[group.name] = group
+            for _, name := range append([]string{group.name}, group.aliases...) {
+                checkDuplicates(cli, name)
+                cli.groups()[name] = group
+            }
+            group.setParent(cli)
+            checkInherited(group, persistentOptions(cli)...)
         }
     }
     return cli
@@ -54,18 +211,26 @@ func addGroups(cli cmdInfo, categories ...*Grp) cmdInfo {
 func addCommands(cli cmdInfo, commands ...*Cmd) cmdInfo {
     for _, command := range commands {
         if command != nil {
-            checkDuplicates(command, command.name)
-            cli.commands()[command.name] = command
+            for _, name := range append([]string{command.name}, command.aliases...) {
+                checkDuplicates(cli, name)
+                cli.commands()[name] = command
+            }
+            command.setParent(cli)
+            checkInherited(command, persistentOptions(cli)...)
         }
     }
     return cli
 }
 
+func withAliases(name string, aliases []string) string {
+    return strings.Join(append([]string{name}, aliases...), ", ")
+}
+
 func checkMissingOptions(cli cmdInfo) error {
     var missingOptions []string
-    for _, option := range cli.options() {
+    for _, option := range uniqueOptions(cli) {
         if option.required && !option.used {
-            missingOptions = append(missingOptions, option.long+" "+option.expects())
+            missingOptions = append(missingOptions, option.name()+" "+option.expects())
         }
     }
     if len(missingOptions) > 0 {
@@ -74,6 +239,23 @@ func checkMissingOptions(cli cmdInfo) error {
     return nil
 }
 
+// checks options of all entered levels once the whole command line is parsed,
+// persistent options may be given below the level they belong to
+func (p *parser) checkOptions() error {
+    var violations []string
+    for _, level := range p.levels {
+        for _, err := range []error{checkMissingOptions(level), checkConstraints(level)} {
+            if err != nil {
+                violations = append(violations, err.Error())
+            }
+        }
+    }
+    if len(violations) > 0 {
+        return errors.New(strings.Join(violations, "\n"))
+    }
+    return nil
+}
+
 func checkDuplicates(cli cmdInfo, name string) {
     if _, exists := cli.groups()[name]; exists {
         panic(fmt.Sprintf("Duplicit group %s", name))
@@ -84,30 +266,104 @@ func checkDuplicates(cli cmdInfo, name string) {
     if _, exists := cli.options()[name]; exists {
         panic(fmt.Sprintf("Duplicit option %s", name))
     }
+    if _, exists := cli.shortOptions()[name]; exists {
+        panic(fmt.Sprintf("Duplicit option %s", name))
+    }
+    if cli.parent() != nil {
+        for _, option := range persistentOptions(cli.parent()) {
+            for _, inherited := range option.names() {
+                if name == inherited {
+                    panic(fmt.Sprintf("Duplicit option %s", name))
+                }
+            }
+        }
+    }
 }
 
-func usage(cli cmdInfo) {
-    Infof("Usage: ")
+func uniqueOptions(cli cmdInfo) []*Option {
+    var options []*Option
+    seen := make(map[*Option]bool)
+    for _, registered := range []map[string]*Option{cli.options(), cli.shortOptions()} {
+        for _, option := range registered {
+            if !seen[option] {
+                seen[option] = true
+                options = append(options, option)
+            }
+        }
+    }
+    sort.Slice(options, func(i, j int) bool {
+        return strings.TrimLeft(options[i].name(), shortPrefix) < strings.TrimLeft(options[j].name(), shortPrefix)
+    })
+    return options
+}
 
-    if cli.commands() != nil {
-        fmt.Printf("%s [OPTIONS] <COMMAND> [ARGS]...\n", cli.trigger())
-    } else {
-        if len(cli.arguments()) > 0 {
-            fmt.Printf("%s [OPTIONS]", cli.trigger())
-            for _, arg := range cli.arguments() {
-                fmt.Printf(" %s", arg.String())
-            }
-            fmt.Println()
-        } else {
-            fmt.Printf("%s [OPTIONS]\n", cli.trigger())
+func uniqueGroups(cli cmdInfo) []*Grp {
+    var groups []*Grp
+    for name, group := range cli.groups() {
+        if name == group.name {
+            groups = append(groups, group)
         }
+    }
+    sort.Slice(groups, func(i, j int) bool {
+        return groups[i].name < groups[j].name
+    })
+    return groups
+}
 
+func uniqueCommands(cli cmdInfo) []*Cmd {
+    var commands []*Cmd
+    for name, command := range cli.commands() {
+        if name == command.name {
+            commands = append(commands, command)
+        }
     }
+    sort.Slice(commands, func(i, j int) bool {
+        return commands[i].name < commands[j].name
+    })
+    return commands
+}
+
+func showHidden() bool {
+    show, _ := strconv.ParseBool(os.Getenv(ShowHiddenEnv))
+    return show
+}
+
+func optionDescription(cli cmdInfo, option *Option) string {
+    description := strings.TrimSpace(option.description())
+    if env := option.envName(envPrefix(cli)); env != "" {
+        description += " [env: " + env + "]"
+    }
+    return deprecated(description, option.deprecation)
+}
+
+func usageLine(cli cmdInfo) string {
+    if cli.commands() != nil {
+        return cli.trigger() + " [OPTIONS] <COMMAND> [ARGS]..."
+    }
+    line := cli.trigger() + " [OPTIONS]"
+    arguments := cli.arguments()
+    for _, arg := range arguments {
+        line += " " + arg.String()
+    }
+    if len(arguments) > 0 && arguments[len(arguments)-1].variadic {
+        return line
+    }
+    if command, ok := cli.(*Cmd); ok && command.count != nil {
+        line += command.count.placeholders(len(arguments))
+    }
+    return line
+}
+
+func usage(cli cmdInfo) {
+    Infof("Usage: ")
+    fmt.Println(usageLine(cli))
     Important("\n" + cli.description())
 
     options := table.New(96, 16, indentSize, false)
-    for _, option := range cli.options() {
-        options.Row(option.trigger(), option.description())
+    for _, option := range uniqueOptions(cli) {
+        if !option.hidden || showHidden() {
+            options.Row(option.trigger(), optionDescription(cli, option))
+        }
     }
 
     if options.Size() > 0 {
@@ -115,13 +371,52 @@ func usage(cli cmdInfo) {
         options.Print()
     }
 
+    if len(cli.optionConstraints()) > 0 {
+        Info("\nConstraints:")
+        for _, constraint := range cli.optionConstraints() {
+            fmt.Println(strings.Repeat(" ", indentSize) + constraint.String())
+        }
+    }
+
+    arguments := table.New(96, 16, indentSize, false)
+    for _, arg := range cli.arguments() {
+        arguments.Row(arg.String(), arg.description())
+    }
+
+    if command, ok := cli.(*Cmd); ok && command.count != nil {
+        Info("\nArguments: " + command.count.String())
+    } else if arguments.Size() > 0 {
+        Info("\nArguments:")
+    }
+    if arguments.Size() > 0 {
+        arguments.Print()
+    }
+
+    if cli.parent() != nil {
+        globalOptions := table.New(96, 16, indentSize, false)
+        for _, option := range persistentOptions(cli.parent()) {
+            if !option.hidden || showHidden() {
+                globalOptions.Row(option.trigger(), optionDescription(cli, option))
+            }
+        }
+
+        if globalOptions.Size() > 0 {
+            Info("\nGlobal options:")
+            globalOptions.Print()
+        }
+    }
+
     commands := table.New(96, 16, indentSize, false)
-    for _, group := range cli.groups() {
-        commands.Row(group.trigger(), group.description())
+    for _, group := range uniqueGroups(cli) {
+        if !group.hidden || showHidden() {
+            commands.Row(withAliases(group.name, group.aliases), deprecated(group.description(), group.deprecation))
+        }
     }
 
-    for _, command := range cli.commands() {
-        commands.Row(command.trigger(), command.description())
+    for _, command := range uniqueCommands(cli) {
+        if !command.hidden || showHidden() {
+            commands.Row(withAliases(command.name, command.aliases), deprecated(command.description(), command.deprecation))
+        }
     }
 
     if commands.Size() > 0 {
@@ -130,82 +425,311 @@ func usage(cli cmdInfo) {
     }
 }
 
-func process(cli cmdInfo, args []string, requiresArg bool) error {
-    for index := 0; index < len(args); index++ {
-        arg := args[index]
-        // options
-        if option, found := cli.options()[arg]; found {
-            if option.argType == flag {
-                if err := option.set("true"); err != nil {
-                    return err
-                }
-            } else {
-                if index+1 >= len(args) {
-                    return errors.New("Missing " + arg + " value")
-                }
-                index++
-                if err := option.set(args[index]); err != nil {
-                    return err
-                }
+func isOption(arg string) bool {
+    return strings.HasPrefix(arg, shortPrefix) && len(arg) > len(shortPrefix)
+}
+
+func splitOption(arg string) (string, string, bool) {
+    if strings.HasPrefix(arg, shortPrefix) {
+        if separator := strings.Index(arg, "="); separator > 0 {
+            return arg[:separator], arg[separator+1:], true
+        }
+    }
+    return arg, "", false
+}
+
+// returns the option registered under name (or under its unique prefix) and the matched name
+func (p *parser) findOption(cli cmdInfo, name string) (*Option, string, error) {
+    options := availableOptions(cli, false)
+    if option, found := options[name]; found {
+        return option, name, nil
+    }
+    if option, found := availableOptions(cli, true)[name]; found {
+        return option, name, nil
+    }
+    if !p.abbreviations || !strings.HasPrefix(name, longPrefix) || name == longPrefix {
+        return nil, name, nil
+    }
+    var candidates []string
+    matches := make(map[*Option]string)
+    for key, option := range options {
+        if strings.HasPrefix(key, name) {
+            candidates = append(candidates, key)
+            matches[option] = key
+        }
+    }
+    if len(matches) > 1 {
+        sort.Strings(candidates)
+        return nil, name, errors.New("Ambiguous option " + name + ", could be " + strings.Join(candidates, ", "))
+    }
+    for option, key := range matches {
+        return option, key, nil
+    }
+    return nil, name, nil
+}
+
+// returns the group or command registered under name (or under its unique prefix)
+func (p *parser) findSubcommand(cli cmdInfo, name string) (*Grp, *Cmd, error) {
+    if group, found := cli.groups()[name]; found {
+        return group, nil, nil
+    }
+    if command, found := cli.commands()[name]; found {
+        return nil, command, nil
+    }
+    if !p.abbreviations || name == "" {
+        return nil, nil, nil
+    }
+    var candidates []string
+    groups := make(map[*Grp]bool)
+    commands := make(map[*Cmd]bool)
+    for key, group := range cli.groups() {
+        if strings.HasPrefix(key, name) {
+            candidates = append(candidates, key)
+            groups[group] = true
+        }
+    }
+    for key, command := range cli.commands() {
+        if strings.HasPrefix(key, name) {
+            candidates = append(candidates, key)
+            commands[command] = true
+        }
+    }
+    if len(groups)+len(commands) > 1 {
+        sort.Strings(candidates)
+        return nil, nil, errors.New("Ambiguous command " + name + ", could be " + strings.Join(candidates, ", "))
+    }
+    for group := range groups {
+        return group, nil, nil
+    }
+    for command := range commands {
+        return nil, command, nil
+    }
+    return nil, nil, nil
+}
+
+func (p *parser) setOption(option *Option, name string, value string, hasValue bool, args []string, index int) (int, error) {
+    if err := p.checkDeprecated("option", name, option.deprecation); err != nil {
+        return index, err
+    }
+    if !hasValue {
+        if !option.takesValue() {
+            if err := option.set(CommandLine, option.implicitValue()); err != nil {
+                return index, fmt.Errorf("Invalid %s value: %s", name, err)
             }
+            return index, nil
+        }
+        if index+1 >= len(args) {
+            return index, errors.New("Missing " + name + " value")
+        }
+        index++
+        value = args[index]
+    }
+    if err := option.set(CommandLine, value); err != nil {
+        return index, fmt.Errorf("Invalid %s value %q: %s", name, value, err)
+    }
+    return index, nil
+}
 
-            // short options
-        } else if option, found := cli.shortOptions()[arg]; found {
-            if option.argType == flag {
-                if err := option.set("true"); err != nil {
-                    return err
-                }
-            } else {
-                if index+1 >= len(args) {
-                    return errors.New("Missing " + arg + " value")
-                }
-                index++
-                if err := option.set(args[index]); err != nil {
-                    return err
+func (p *parser) parseOption(cli cmdInfo, args []string, index int) (int, bool, error) {
+    arg := args[index]
+    name, value, hasValue := splitOption(arg)
+    if option, name, err := p.findOption(cli, name); err != nil {
+        return index, true, err
+    } else if option != nil {
+        if option.negatable && name == option.negation() {
+            enabled := true
+            if hasValue {
+                parsed, err := strconv.ParseBool(value)
+                if err != nil {
+                    return index, true, fmt.Errorf("Invalid %s value %q: %s", name, value, err)
                 }
+                enabled = parsed
             }
+            value, hasValue = strconv.FormatBool(!enabled), true
+        }
+        next, err := p.setOption(option, name, value, hasValue, args, index)
+        return next, true, err
+    }
+    if strings.HasPrefix(arg, longPrefix) || !strings.HasPrefix(arg, shortPrefix) || len(arg) < 3 {
+        return index, false, nil
+    }
 
-            // groups
-        } else if group, found := cli.groups()[arg]; found {
-            if err := checkMissingOptions(cli); err != nil {
-                return err
+    // short options cluster (-abc, -n5, -an=5)
+    for position := 1; position < len(arg); position++ {
+        name := shortPrefix + string(arg[position])
+        option, found := availableOptions(cli, true)[name]
+        if !found {
+            if position == 1 {
+                // not an option cluster at all
+                return index, false, nil
             }
-            if err := checkMissingOptions(cli); err != nil {
-                return err
+            return index, true, errors.New("Unknown option " + name + " in " + arg)
+        }
+        rest := arg[position+1:]
+        if !option.takesValue() && !strings.HasPrefix(rest, "=") {
+            if _, err := p.setOption(option, name, "", false, args, index); err != nil {
+                return index, true, err
             }
-            return process(group, args[index+1:], true)
+            continue
+        }
+        if rest == "" {
+            next, err := p.setOption(option, name, "", false, args, index)
+            return next, true, err
+        }
+        next, err := p.setOption(option, name, strings.TrimPrefix(rest, "="), true, args, index)
+        return next, true, err
+    }
+    return index, true, nil
+}
 
-            //commands
-        } else if command, found := cli.commands()[arg]; found {
-            if err := checkMissingOptions(cli); err != nil {
-                return err
+type parser struct {
+    mode          ParsingMode
+    abbreviations bool
+    strict        bool
+    envPrefix     string
+    config        map[string]interface{}
+    configFile    string
+    configPath    string
+    printConfig   *bool
+    levels        []cmdInfo
+}
+
+// warns about use of deprecated item, fails in strict mode
+func (p *parser) checkDeprecated(kind string, name string, deprecation *deprecation) error {
+    if deprecation == nil {
+        return nil
+    }
+    message := kind + " " + name + " is " + deprecation.String()
+    if p.strict {
+        return errors.New(strings.ToUpper(message[:1]) + message[1:])
+    }
+    Warn("Warning:", message)
+    return nil
+}
+
+func (p *parser) process(cli cmdInfo, args []string, optionsEnded bool) error {
+    if err := p.resolve(cli); err != nil {
+        return err
+    }
+    for index := 0; index < len(args); index++ {
+        arg := args[index]
+        if !optionsEnded {
+            // end of options
+            if arg == endOfOptions {
+                optionsEnded = true
+                continue
             }
-            if err := process(command, args[index+1:], false); err != nil {
+            // options
+            if next, found, err := p.parseOption(cli, args, index); err != nil {
                 return err
+            } else if found {
+                index = next
+                continue
             }
-            if err := checkMissingOptions(cli); err != nil {
+        }
+
+        group, command, err := p.findSubcommand(cli, arg)
+        if err != nil {
+            return err
+        }
+
+        if group != nil || command != nil {
+            if err := p.leave(cli); err != nil {
                 return err
             }
-            if err := checkMissingOptions(command); err != nil {
+        }
+
+        // groups
+        if group != nil {
+            if err := p.checkDeprecated("group", arg, group.deprecation); err != nil {
                 return err
             }
-            return command.handler(args[index+1:])
-        } else {
-            if requiresArg {
-                return errors.New("Unknown argument: " + arg)
-            } else {
-                break
+            p.enterConfig(group.name)
+            return p.process(group, args[index+1:], optionsEnded)
+
+            //commands
+        } else if command != nil {
+            if err := p.checkDeprecated("command", arg, command.deprecation); err != nil {
+                return err
             }
+            p.enterConfig(command.name)
+            return p.run(command, args[index+1:], optionsEnded)
         }
+        return errors.New("Unknown argument: " + arg)
     }
 
-    if requiresArg {
-        cli.Usage()
+    if err := p.leave(cli); err != nil {
+        return err
+    }
+    if p.printConfig != nil && *p.printConfig {
+        p.print()
         return nil
     }
+    if err := p.checkOptions(); err != nil {
+        return err
+    }
+    cli.Usage()
     return nil
 }
 
+// finishes options of the level before descending or printing usage
+func (p *parser) leave(cli cmdInfo) error {
+    if root, ok := cli.(*Cli); ok {
+        return p.loadRootConfig(root)
+    }
+    return nil
+}
+
+func (p *parser) run(command *Cmd, args []string, optionsEnded bool) error {
+    if err := p.resolve(command); err != nil {
+        return err
+    }
+    var arguments, passthrough []string
+    if optionsEnded {
+        passthrough, args = args, nil
+    }
+    for index := 0; index < len(args); index++ {
+        arg := args[index]
+        // end of options, the rest is passed through verbatim
+        if arg == endOfOptions {
+            passthrough = args[index+1:]
+            break
+        }
+        // POSIX accepts options only before the first argument, GNU anywhere
+        if len(arguments) == 0 || p.mode == GnuMode {
+            if next, found, err := p.parseOption(command, args, index); err != nil {
+                return err
+            } else if found {
+                index = next
+                continue
+            } else if p.mode == GnuMode && isOption(arg) {
+                return errors.New("Unknown option: " + arg)
+            }
+        }
+        arguments = append(arguments, arg)
+    }
+
+    // values are printed even when they are incomplete
+    if p.printConfig != nil && *p.printConfig {
+        p.print()
+        return nil
+    }
+    if err := p.checkOptions(); err != nil {
+        return err
+    }
+    operands := arguments
+    if !command.passthrough {
+        operands = append(append([]string{}, arguments...), passthrough...)
+    }
+    if err := bindArguments(command.args, operands); err != nil {
+        return err
+    }
+    if count := command.argCount(); count != nil && !count.accepts(len(operands)) {
+        return fmt.Errorf("%s expects %s, got %d\nUsage: %s", command.name, count, len(operands), usageLine(command))
+    }
+    return command.handler(arguments, passthrough)
+}
+
 func Sentence(format string, args ...interface{}) string {
     text := fmt.Sprintf(format, args...)
     text = strings.TrimSpace(text)
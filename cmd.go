@@ -1,48 +1,69 @@
 package cli
 
-type Arg struct {
-    desc     string
-    mandatory bool
+type Cmd struct {
+    name        string
+    aliases     []string
+    desc        string
+    hidden      bool
+    deprecation *deprecation
+    parentInfo  cmdInfo
+    opts        map[string]*Option
+    shortOpts   map[string]*Option
+    constraints []*Constraint
+    args        []*Arg
+    count       *ArgCount
+    handler     func([]string, []string) error
+    passthrough bool
+}
+
+func PassthroughCommandWithoutHelp(handler func([]string, []string) error, name string, description string, options ...*Option) *Cmd {
+    command := &Cmd{
+        name:        Escape(name),
+        desc:        Sentence(description),
+        opts:        make(map[string]*Option),
+        shortOpts:   make(map[string]*Option),
+        handler:     handler,
+        passthrough: true}
+    command.AddOptions(options...)
+    return command
 }
 
-func (a *Arg) String() string {
-    if a.mandatory {
-        return "<" + a.desc + ">"
-    }
-    return "[" + a.desc + "]"
+func PassthroughCommand(handler func([]string, []string) error, name string, description string, options ...*Option) *Cmd {
+    return PassthroughCommandWithoutHelp(handler, name, description, options...).AddHelp()
 }
 
-func Argument(desc string) *Arg {
-    return &Arg{desc: desc}
+func CommandWithoutHelp(handler func([]string) error, name string, description string, options ...*Option) *Cmd {
+    command := PassthroughCommandWithoutHelp(func(arguments []string, passthrough []string) error {
+        return handler(append(append([]string{}, arguments...), passthrough...))
+    }, name, description, options...)
+    command.passthrough = false
+    return command
 }
 
-func Mandatory(arg *Arg) *Arg {
-    arg.mandatory = true
-    return arg
+func Command(handler func([]string) error, name string, description string, options ...*Option) *Cmd {
+    return CommandWithoutHelp(handler, name, description, options...).AddHelp()
 }
 
-type Cmd struct {
-    name      string
-    desc      string
-    opts      map[string]*Option
-    shortOpts map[string]*Option
-    args      []*Arg
-    handler   func([]string) error
+func (c *Cmd) AddAliases(aliases ...string) *Cmd {
+    for _, alias := range aliases {
+        alias = Escape(alias)
+        if c.parentInfo != nil {
+            checkDuplicates(c.parentInfo, alias)
+            c.parentInfo.commands()[alias] = c
+        }
+        c.aliases = append(c.aliases, alias)
+    }
+    return c
 }
 
-func CommandWithoutHelp(handler func([]string) error, name string, description string, options ...*Option) *Cmd {
-    command := &Cmd{
-        name:      Escape(name),
-        desc:      Sentence(description),
-        opts:      make(map[string]*Option),
-        shortOpts: make(map[string]*Option),
-        handler:   handler}
-    command.AddOptions(options...)
-    return command
+func (c *Cmd) Hide() *Cmd {
+    c.hidden = true
+    return c
 }
 
-func Command(handler func([]string) error, name string, description string, options ...*Option) *Cmd {
-    return CommandWithoutHelp(handler, name, description, options...).AddHelp()
+func (c *Cmd) Deprecate(message string, replacement string) *Cmd {
+    c.deprecation = &deprecation{message: message, replacement: replacement}
+    return c
 }
 
 func (c *Cmd) AddHelp() *Cmd {
@@ -53,13 +74,38 @@ func (c *Cmd) AddOptions(options ...*Option) *Cmd {
     return addOptions(c, options...).(*Cmd)
 }
 
+func (c *Cmd) AddConstraints(constraints ...*Constraint) *Cmd {
+    return addConstraints(c, constraints...).(*Cmd)
+}
+
+func (c *Cmd) AddExclusive(options ...*Option) *Cmd {
+    return c.AddConstraints(MutuallyExclusive(options...))
+}
+
 func (c *Cmd) AddArguments(arguments ...*Arg) *Cmd {
     for _, argument := range arguments {
+        if len(c.args) > 0 && c.args[len(c.args)-1].variadic {
+            panic("Argument " + argument.String() + " can't follow variadic argument " + c.args[len(c.args)-1].String())
+        }
         c.args = append(c.args, argument)
     }
     return c
 }
 
+func (c *Cmd) ExpectArgs(count ArgCount) *Cmd {
+    c.count = &count
+    return c
+}
+
+// explicitly expected number of arguments or the one derived from declared arguments
+func (c *Cmd) argCount() *ArgCount {
+    if c.count == nil && len(c.args) > 0 {
+        count := argCountOf(c.args)
+        return &count
+    }
+    return c.count
+}
+
 func (c *Cmd) Usage() {
     usage(c)
 }
@@ -76,6 +122,10 @@ func (c *Cmd) shortOptions() map[string]*Option {
     return c.shortOpts
 }
 
+func (c *Cmd) optionConstraints() []*Constraint {
+    return c.constraints
+}
+
 func (c *Cmd) groups() map[string]*Grp {
     // command has no sub-groups
     return nil
@@ -86,6 +136,14 @@ func (c *Cmd) commands() map[string]*Cmd {
     return nil
 }
 
+func (c *Cmd) parent() cmdInfo {
+    return c.parentInfo
+}
+
+func (c *Cmd) setParent(parent cmdInfo) {
+    c.parentInfo = parent
+}
+
 func (c *Cmd) trigger() string {
     return c.name
 }
@@ -0,0 +1,591 @@
+package cli
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+type ConfigDecoder func(data []byte) (map[string]interface{}, error)
+
+var configFormats = map[string]ConfigDecoder{
+    ".json": decodeJson,
+    ".yaml": decodeYaml,
+    ".yml":  decodeYaml,
+    ".toml": decodeToml,
+    ".ini":  decodeIni,
+    ".conf": decodeIni,
+    ".cfg":  decodeIni,
+}
+
+// registers (or replaces) decoder of configuration files with given extension
+func RegisterConfigFormat(extension string, decoder ConfigDecoder) {
+    configFormats["."+strings.TrimPrefix(strings.ToLower(extension), ".")] = decoder
+}
+
+func expandHome(path string) string {
+    if path == "~" || strings.HasPrefix(path, "~/") {
+        if home, err := os.UserHomeDir(); err == nil {
+            return filepath.Join(home, path[1:])
+        }
+    }
+    return path
+}
+
+// loads configuration file given by root --config option or the first one found in search paths;
+// it is applied once the root options are parsed, values given there take precedence anyway
+func (p *parser) loadRootConfig(c *Cli) error {
+    if c.configOpt == nil {
+        return nil
+    }
+    var path string
+    if c.configOpt.given() {
+        path = expandHome(c.configOpt.values[0])
+    } else {
+        for _, searchPath := range c.configPaths {
+            searchPath = expandHome(searchPath)
+            if _, err := os.Stat(searchPath); err == nil {
+                path = searchPath
+                break
+            }
+        }
+    }
+    if path == "" {
+        return nil
+    }
+    config, err := loadConfig(path)
+    if err != nil {
+        return err
+    }
+    p.config, p.configFile = config, path
+    return p.applyConfig(c)
+}
+
+func loadConfig(path string) (map[string]interface{}, error) {
+    decoder, found := configFormats[strings.ToLower(filepath.Ext(path))]
+    if !found {
+        return nil, errors.New("Unsupported format of configuration file " + path)
+    }
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    config, err := decoder(data)
+    if err != nil {
+        return nil, fmt.Errorf("Invalid configuration file %s: %s", path, err)
+    }
+    return config, nil
+}
+
+// section of the configuration belonging to group or command
+func configSection(config map[string]interface{}, name string) map[string]interface{} {
+    if section, ok := config[name].(map[string]interface{}); ok {
+        return section
+    }
+    return nil
+}
+
+// converts configuration value to the values as they would be given on command line
+func configValues(option *Option, value interface{}) ([]string, error) {
+    switch typed := value.(type) {
+    case nil:
+        return nil, nil
+    case []interface{}:
+        if !option.multi && len(typed) > 1 {
+            return nil, errors.New("expected single value")
+        }
+        var values []string
+        for _, item := range typed {
+            converted, err := configValues(option, item)
+            if err != nil {
+                return nil, err
+            }
+            values = append(values, converted...)
+        }
+        return values, nil
+    case map[string]interface{}:
+        if option.argType != keyValue {
+            return nil, errors.New("expected single value")
+        }
+        var values []string
+        for key, item := range typed {
+            converted, err := configValues(option, item)
+            if err != nil || len(converted) != 1 {
+                return nil, fmt.Errorf("invalid value of key %s", key)
+            }
+            values = append(values, key+"="+converted[0])
+        }
+        sort.Strings(values)
+        return values, nil
+    case string:
+        return []string{typed}, nil
+    case bool:
+        return []string{strconv.FormatBool(typed)}, nil
+    case float64:
+        return []string{strconv.FormatFloat(typed, 'f', -1, 64)}, nil
+    default:
+        return []string{fmt.Sprint(typed)}, nil
+    }
+}
+
+// applies values from configuration section to options of the level
+func (p *parser) applyConfig(cli cmdInfo) error {
+    keys := make([]string, 0, len(p.config))
+    for key := range p.config {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        name := longPrefix + key
+        // persistent options of ancestors may be set in sections of sub-commands too
+        option, found := availableOptions(cli, false)[name]
+        if !found {
+            if group, command, _ := p.findSubcommand(cli, key); group != nil || command != nil {
+                // section of sub-command
+                continue
+            }
+            return fmt.Errorf("Unknown option %s%s in configuration file %s", p.configPath, key, p.configFile)
+        }
+        if option.builtin {
+            continue
+        }
+        values, err := configValues(option, p.config[key])
+        if err != nil {
+            return fmt.Errorf("Invalid %s%s value in configuration file %s: %s", p.configPath, key, p.configFile, err)
+        }
+        for _, value := range values {
+            if option.negatable && name == option.negation() {
+                // no-<name> inverts the value
+                enabled, err := strconv.ParseBool(value)
+                if err != nil {
+                    return fmt.Errorf("Invalid %s%s value %q in configuration file %s: %s", p.configPath, key, value, p.configFile, err)
+                }
+                value = strconv.FormatBool(!enabled)
+            }
+            if err := option.set(ConfigFile, value); err != nil {
+                return fmt.Errorf("Invalid %s%s value %q in configuration file %s: %s", p.configPath, key, value, p.configFile, err)
+            }
+        }
+    }
+    return nil
+}
+
+// descends into configuration section of group or command
+func (p *parser) enterConfig(name string) {
+    p.config = configSection(p.config, name)
+    p.configPath += name + "."
+}
+
+func decodeJson(data []byte) (map[string]interface{}, error) {
+    config := make(map[string]interface{})
+    err := json.Unmarshal(data, &config)
+    return config, err
+}
+
+// strips comment starting with any of markers outside of quotes
+func stripComment(text string, markers string) string {
+    var quote rune
+    for index, char := range text {
+        switch {
+        case quote != 0:
+            if char == quote {
+                quote = 0
+            }
+        case (char == '"' || char == '\'') && opensQuote(text, index):
+            quote = char
+        case strings.ContainsRune(markers, char) && (index == 0 || text[index-1] == ' ' || text[index-1] == '\t'):
+            return text[:index]
+        }
+    }
+    return text
+}
+
+// quotes start values, apostrophes within words are not quotes
+func opensQuote(text string, index int) bool {
+    return index == 0 || strings.ContainsRune(" \t=:[{,", rune(text[index-1]))
+}
+
+// splits by separator outside of quotes and brackets
+func splitOutside(text string, separator rune) []string {
+    var parts []string
+    var quote rune
+    depth, start := 0, 0
+    for index, char := range text {
+        switch {
+        case quote != 0:
+            if char == quote {
+                quote = 0
+            }
+        case (char == '"' || char == '\'') && opensQuote(text, index):
+            quote = char
+        case char == '[' || char == '{':
+            depth++
+        case char == ']' || char == '}':
+            depth--
+        case char == separator && depth == 0:
+            parts = append(parts, text[start:index])
+            start = index + 1
+        }
+    }
+    return append(parts, text[start:])
+}
+
+func unquote(text string) string {
+    text = strings.TrimSpace(text)
+    if len(text) >= 2 {
+        switch {
+        case text[0] == '"' && text[len(text)-1] == '"':
+            if unquoted, err := strconv.Unquote(text); err == nil {
+                return unquoted
+            }
+            return text[1 : len(text)-1]
+        case text[0] == '\'' && text[len(text)-1] == '\'':
+            return strings.Replace(text[1:len(text)-1], "''", "'", -1)
+        }
+    }
+    return text
+}
+
+// parses scalars, [a, b] lists and {k: v} tables, separator divides keys and values of tables
+func parseInline(text string, separator rune) (interface{}, error) {
+    text = strings.TrimSpace(text)
+    switch {
+    case strings.HasPrefix(text, "["):
+        if !strings.HasSuffix(text, "]") {
+            return nil, errors.New("unterminated list " + text)
+        }
+        list := []interface{}{}
+        if strings.TrimSpace(text[1:len(text)-1]) == "" {
+            return list, nil
+        }
+        items := splitOutside(text[1:len(text)-1], ',')
+        if strings.TrimSpace(items[len(items)-1]) == "" {
+            // trailing comma
+            items = items[:len(items)-1]
+        }
+        for _, item := range items {
+            value, err := parseInline(item, separator)
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, value)
+        }
+        return list, nil
+    case strings.HasPrefix(text, "{"):
+        if !strings.HasSuffix(text, "}") {
+            return nil, errors.New("unterminated table " + text)
+        }
+        table := make(map[string]interface{})
+        if strings.TrimSpace(text[1:len(text)-1]) == "" {
+            return table, nil
+        }
+        for _, item := range splitOutside(text[1:len(text)-1], ',') {
+            pair := splitOutside(item, separator)
+            if len(pair) < 2 {
+                return nil, errors.New("invalid table item " + item)
+            }
+            value, err := parseInline(strings.Join(pair[1:], string(separator)), separator)
+            if err != nil {
+                return nil, err
+            }
+            table[unquote(pair[0])] = value
+        }
+        return table, nil
+    default:
+        return unquote(text), nil
+    }
+}
+
+// returns table under dotted path, creates missing tables
+func configTable(config map[string]interface{}, path string) (map[string]interface{}, error) {
+    table := config
+    for _, name := range strings.Split(path, ".") {
+        name = unquote(name)
+        switch section := table[name].(type) {
+        case nil:
+            created := make(map[string]interface{})
+            table[name] = created
+            table = created
+        case map[string]interface{}:
+            table = section
+        default:
+            return nil, errors.New("key " + name + " is not a section")
+        }
+    }
+    return table, nil
+}
+
+// bracket depth of the text outside of quotes, positive when a list or table is not closed yet
+func openBrackets(text string) int {
+    var quote rune
+    depth := 0
+    for index, char := range text {
+        switch {
+        case quote != 0:
+            if char == quote {
+                quote = 0
+            }
+        case (char == '"' || char == '\'') && opensQuote(text, index):
+            quote = char
+        case char == '[' || char == '{':
+            depth++
+        case char == ']' || char == '}':
+            depth--
+        }
+    }
+    return depth
+}
+
+// supports key = value pairs with strings, numbers, booleans, inline and multi-line arrays, inline tables,
+// [table] and [[array of tables]] headers; multi-line strings are rejected
+func decodeToml(data []byte) (map[string]interface{}, error) {
+    config := make(map[string]interface{})
+    table := config
+    lines := strings.Split(string(data), "\n")
+    for index := 0; index < len(lines); index++ {
+        number := index + 1
+        line := strings.TrimSpace(stripComment(lines[index], "#"))
+        if line == "" {
+            continue
+        }
+        if strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]") {
+            section, err := configTableArray(config, strings.TrimSpace(line[2:len(line)-2]))
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %s", number, err)
+            }
+            table = section
+            continue
+        }
+        if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+            section, err := configTable(config, strings.TrimSpace(line[1:len(line)-1]))
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %s", number, err)
+            }
+            table = section
+            continue
+        }
+        pair := splitOutside(line, '=')
+        if len(pair) < 2 {
+            return nil, fmt.Errorf("line %d: expected key = value", number)
+        }
+        text := strings.TrimSpace(strings.Join(pair[1:], "="))
+        if strings.HasPrefix(text, `"""`) || strings.HasPrefix(text, "'''") {
+            return nil, fmt.Errorf("line %d: multi-line strings are not supported", number)
+        }
+        // arrays may continue on following lines
+        for openBrackets(text) > 0 && index+1 < len(lines) {
+            index++
+            text += " " + strings.TrimSpace(stripComment(lines[index], "#"))
+        }
+        value, err := parseInline(text, '=')
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %s", number, err)
+        }
+        table[unquote(pair[0])] = value
+    }
+    return config, nil
+}
+
+// appends new table to the array of tables under dotted path
+func configTableArray(config map[string]interface{}, path string) (map[string]interface{}, error) {
+    table, name := config, path
+    if separator := strings.LastIndex(path, "."); separator >= 0 {
+        parent, err := configTable(config, path[:separator])
+        if err != nil {
+            return nil, err
+        }
+        table, name = parent, path[separator+1:]
+    }
+    name = unquote(name)
+    created := make(map[string]interface{})
+    switch existing := table[name].(type) {
+    case nil:
+        table[name] = []interface{}{created}
+    case []interface{}:
+        table[name] = append(existing, created)
+    default:
+        return nil, errors.New("key " + name + " is not an array of tables")
+    }
+    return created, nil
+}
+
+func decodeIni(data []byte) (map[string]interface{}, error) {
+    config := make(map[string]interface{})
+    table := config
+    for number, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSpace(stripComment(line, "#;"))
+        if line == "" {
+            continue
+        }
+        if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+            section, err := configTable(config, strings.TrimSpace(line[1:len(line)-1]))
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %s", number+1, err)
+            }
+            table = section
+            continue
+        }
+        separator := strings.IndexAny(line, "=:")
+        if separator < 0 {
+            return nil, fmt.Errorf("line %d: expected key = value", number+1)
+        }
+        key, value := strings.TrimSpace(line[:separator]), unquote(line[separator+1:])
+        // repeated keys make a list
+        switch existing := table[key].(type) {
+        case nil:
+            table[key] = value
+        case []interface{}:
+            table[key] = append(existing, value)
+        default:
+            table[key] = []interface{}{existing, value}
+        }
+    }
+    return config, nil
+}
+
+type yamlLine struct {
+    number int
+    indent int
+    text   string
+}
+
+// supports nested mappings, sequences (of scalars, mappings or sequences), flow [lists] and {mappings}
+// and plain or quoted scalars; block scalars, anchors, aliases, tags and multiple documents are rejected
+func decodeYaml(data []byte) (map[string]interface{}, error) {
+    var lines []yamlLine
+    for number, line := range strings.Split(string(data), "\n") {
+        text := strings.TrimRight(stripComment(line, "#"), " \t\r")
+        trimmed := strings.TrimLeft(text, " ")
+        if trimmed == "" || (trimmed == "---" && len(lines) == 0) {
+            continue
+        }
+        if trimmed == "---" || trimmed == "..." {
+            return nil, fmt.Errorf("line %d: multiple documents are not supported", number+1)
+        }
+        lines = append(lines, yamlLine{number: number + 1, indent: len(text) - len(trimmed), text: trimmed})
+    }
+    if len(lines) == 0 {
+        return make(map[string]interface{}), nil
+    }
+    value, next, err := parseYamlBlock(lines, 0, lines[0].indent)
+    if err != nil {
+        return nil, err
+    }
+    if next < len(lines) {
+        return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+    }
+    config, ok := value.(map[string]interface{})
+    if !ok {
+        return nil, errors.New("expected mapping at top level")
+    }
+    return config, nil
+}
+
+func isYamlItem(text string) bool {
+    return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// key: value or key: with nested block, colons within scalars (e.g. URLs) don't count
+func isYamlMapping(text string) bool {
+    if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+        return false
+    }
+    pair := splitOutside(text, ':')
+    for _, value := range pair[1:] {
+        if value == "" || value[0] == ' ' || value[0] == '\t' {
+            return true
+        }
+    }
+    return false
+}
+
+func parseYamlScalar(text string, number int) (interface{}, error) {
+    switch {
+    case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+        return nil, fmt.Errorf("line %d: block scalars are not supported", number)
+    case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*"):
+        return nil, fmt.Errorf("line %d: anchors and aliases are not supported", number)
+    case strings.HasPrefix(text, "!"):
+        return nil, fmt.Errorf("line %d: tags are not supported", number)
+    case text == "~" || text == "null" || text == "Null" || text == "NULL":
+        return nil, nil
+    }
+    value, err := parseInline(text, ':')
+    if err != nil {
+        return nil, fmt.Errorf("line %d: %s", number, err)
+    }
+    return value, nil
+}
+
+// parses mapping or sequence of lines with given indentation
+func parseYamlBlock(lines []yamlLine, index int, indent int) (interface{}, int, error) {
+    if isYamlItem(lines[index].text) {
+        list := []interface{}{}
+        for index < len(lines) && lines[index].indent == indent && isYamlItem(lines[index].text) {
+            rest := strings.TrimPrefix(lines[index].text, "-")
+            item := strings.TrimSpace(rest)
+            if item != "" && (isYamlMapping(item) || isYamlItem(item)) {
+                // nested block starts on the item line, its indentation is the one of the item content
+                nested := indent + 1 + len(rest) - len(strings.TrimLeft(rest, " "))
+                lines[index] = yamlLine{number: lines[index].number, indent: nested, text: item}
+                value, next, err := parseYamlBlock(lines, index, nested)
+                if err != nil {
+                    return nil, next, err
+                }
+                list, index = append(list, value), next
+                continue
+            }
+            index++
+            if item != "" {
+                value, err := parseYamlScalar(item, lines[index-1].number)
+                if err != nil {
+                    return nil, index, err
+                }
+                list = append(list, value)
+            } else if index < len(lines) && lines[index].indent > indent {
+                value, next, err := parseYamlBlock(lines, index, lines[index].indent)
+                if err != nil {
+                    return nil, next, err
+                }
+                list, index = append(list, value), next
+            } else {
+                list = append(list, nil)
+            }
+        }
+        return list, index, nil
+    }
+
+    mapping := make(map[string]interface{})
+    for index < len(lines) && lines[index].indent == indent {
+        line := lines[index]
+        pair := splitOutside(line.text, ':')
+        if len(pair) < 2 || isYamlItem(line.text) {
+            return nil, index, fmt.Errorf("line %d: expected key: value", line.number)
+        }
+        key, text := unquote(pair[0]), strings.TrimSpace(strings.Join(pair[1:], ":"))
+        index++
+        switch {
+        case text != "":
+            value, err := parseYamlScalar(text, line.number)
+            if err != nil {
+                return nil, index, err
+            }
+            mapping[key] = value
+        case index < len(lines) && (lines[index].indent > indent || (lines[index].indent == indent && isYamlItem(lines[index].text))):
+            value, next, err := parseYamlBlock(lines, index, lines[index].indent)
+            if err != nil {
+                return nil, next, err
+            }
+            mapping[key], index = value, next
+        default:
+            mapping[key] = nil
+        }
+    }
+    return mapping, index, nil
+}
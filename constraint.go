@@ -0,0 +1,169 @@
+package cli
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// rule between options checked after the whole command line is parsed, defaults never count as given
+type Constraint struct {
+    options []*Option
+    subject string
+    rule    string
+    check   func() error
+}
+
+func (c *Constraint) String() string {
+    return c.subject + " " + c.rule
+}
+
+// given explicitly, defaults don't count
+func (o *Option) given() bool {
+    return o.source > DefaultValue
+}
+
+func givenOptions(options []*Option) []*Option {
+    var given []*Option
+    for _, option := range options {
+        if option.given() {
+            given = append(given, option)
+        }
+    }
+    return given
+}
+
+func names(options []*Option) []string {
+    var names []string
+    for _, option := range options {
+        names = append(names, option.name())
+    }
+    return names
+}
+
+func checkConstraintOptions(options []*Option) {
+    if len(options) < 2 {
+        panic(fmt.Sprintf("Constraint needs at least two options, got %d", len(options)))
+    }
+    for _, option := range options {
+        if option == nil {
+            panic("Constraint option can't be nil")
+        }
+    }
+}
+
+// at most one of the options can be given
+func MutuallyExclusive(options ...*Option) *Constraint {
+    checkConstraintOptions(options)
+    return &Constraint{
+        options: options,
+        subject: strings.Join(names(options), ", "),
+        rule:    "are mutually exclusive",
+        check: func() error {
+            if given := givenOptions(options); len(given) > 1 {
+                return errors.New("options " + strings.Join(names(given), ", ") + " are mutually exclusive")
+            }
+            return nil
+        }}
+}
+
+// at least one of the options has to be given
+func AtLeastOneOf(options ...*Option) *Constraint {
+    checkConstraintOptions(options)
+    return &Constraint{
+        options: options,
+        subject: strings.Join(names(options), ", "),
+        rule:    "at least one is required",
+        check: func() error {
+            if len(givenOptions(options)) == 0 {
+                return errors.New("at least one of options " + strings.Join(names(options), ", ") + " is required")
+            }
+            return nil
+        }}
+}
+
+// when the option is given, all the required ones have to be given too
+func Requires(option *Option, required ...*Option) *Constraint {
+    checkConstraintOptions(append([]*Option{option}, required...))
+    return &Constraint{
+        options: append([]*Option{option}, required...),
+        subject: option.name(),
+        rule:    "requires " + strings.Join(names(required), ", "),
+        check: func() error {
+            if !option.given() {
+                return nil
+            }
+            var missing []*Option
+            for _, other := range required {
+                if !other.given() {
+                    missing = append(missing, other)
+                }
+            }
+            if len(missing) > 0 {
+                return errors.New("option " + option.name() + " requires " + strings.Join(names(missing), ", "))
+            }
+            return nil
+        }}
+}
+
+// the option is required when any of the conditions is given
+func RequiredIf(option *Option, conditions ...*Option) *Constraint {
+    checkConstraintOptions(append([]*Option{option}, conditions...))
+    return &Constraint{
+        options: append([]*Option{option}, conditions...),
+        subject: option.name(),
+        rule:    "is required if " + strings.Join(names(conditions), " or ") + " is given",
+        check: func() error {
+            if option.given() {
+                return nil
+            }
+            if given := givenOptions(conditions); len(given) > 0 {
+                return errors.New("option " + option.name() + " is required if " + strings.Join(names(given), ", ") + " is given")
+            }
+            return nil
+        }}
+}
+
+func addConstraints(cli cmdInfo, constraints ...*Constraint) cmdInfo {
+    for _, constraint := range constraints {
+        for _, option := range constraint.options {
+            if !isAvailable(cli, option) {
+                panic("Constrained option " + option.name() + " is not an option of " + cli.trigger())
+            }
+        }
+        switch cli := cli.(type) {
+        case *Cli:
+            cli.constraints = append(cli.constraints, constraint)
+        case *Grp:
+            cli.constraints = append(cli.constraints, constraint)
+        case *Cmd:
+            cli.constraints = append(cli.constraints, constraint)
+        }
+    }
+    return cli
+}
+
+func isAvailable(cli cmdInfo, option *Option) bool {
+    for _, short := range []bool{false, true} {
+        for _, available := range availableOptions(cli, short) {
+            if available == option {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// reports all violated constraints at once
+func checkConstraints(cli cmdInfo) error {
+    var violations []string
+    for _, constraint := range cli.optionConstraints() {
+        if err := constraint.check(); err != nil {
+            violations = append(violations, err.Error())
+        }
+    }
+    if len(violations) > 0 {
+        return errors.New(strings.Join(violations, "\n"))
+    }
+    return nil
+}
@@ -1,12 +1,17 @@
 package cli
 
 type Grp struct {
-    name      string
-    desc      string
-    opts      map[string]*Option
-    shortOpts map[string]*Option
-    cmds      map[string]*Cmd
-    grps      map[string]*Grp
+    name        string
+    aliases     []string
+    desc        string
+    hidden      bool
+    deprecation *deprecation
+    parentInfo  cmdInfo
+    opts        map[string]*Option
+    shortOpts   map[string]*Option
+    cmds        map[string]*Cmd
+    grps        map[string]*Grp
+    constraints []*Constraint
 }
 
 func GroupWithoutHelp(name string, description string, commands ...*Cmd) *Grp {
@@ -25,6 +30,28 @@ func Group(name string, description string, commands ...*Cmd) *Grp {
     return GroupWithoutHelp(name, description, commands...).AddHelp()
 }
 
+func (g *Grp) AddAliases(aliases ...string) *Grp {
+    for _, alias := range aliases {
+        alias = Escape(alias)
+        if g.parentInfo != nil {
+            checkDuplicates(g.parentInfo, alias)
+            g.parentInfo.groups()[alias] = g
+        }
+        g.aliases = append(g.aliases, alias)
+    }
+    return g
+}
+
+func (g *Grp) Hide() *Grp {
+    g.hidden = true
+    return g
+}
+
+func (g *Grp) Deprecate(message string, replacement string) *Grp {
+    g.deprecation = &deprecation{message: message, replacement: replacement}
+    return g
+}
+
 func (g *Grp) AddHelp() *Grp {
     return addHelp(g).(*Grp)
 }
@@ -37,6 +64,14 @@ func (g *Grp) AddOptions(options ...*Option) *Grp {
     return addOptions(g, options...).(*Grp)
 }
 
+func (g *Grp) AddConstraints(constraints ...*Constraint) *Grp {
+    return addConstraints(g, constraints...).(*Grp)
+}
+
+func (g *Grp) AddExclusive(options ...*Option) *Grp {
+    return g.AddConstraints(MutuallyExclusive(options...))
+}
+
 func (g *Grp) AddCommands(commands ...*Cmd) *Grp {
     return addCommands(g, commands...).(*Grp)
 }
@@ -53,6 +88,10 @@ func (g *Grp) shortOptions() map[string]*Option {
     return g.shortOpts
 }
 
+func (g *Grp) optionConstraints() []*Constraint {
+    return g.constraints
+}
+
 func (g *Grp) groups() map[string]*Grp {
     return g.grps
 }
@@ -61,6 +100,14 @@ func (g *Grp) commands() map[string]*Cmd {
     return g.cmds
 }
 
+func (g *Grp) parent() cmdInfo {
+    return g.parentInfo
+}
+
+func (g *Grp) setParent(parent cmdInfo) {
+    g.parentInfo = parent
+}
+
 func (g *Grp) trigger() string {
     return g.name
 }
@@ -2,12 +2,20 @@ package cli
 
 import (
     "strconv"
+    "strings"
     "fmt"
     "time"
 )
 
 type optionType string
 
+const (
+    // short name of long-only options
+    NoShort byte = 0
+    // long name of short-only options
+    NoLong = ""
+)
+
 const (
     flag     optionType = "flag"
     value    optionType = "value"
@@ -15,102 +23,453 @@ const (
     float    optionType = "floating-point number"
     duration optionType = "duration"
     path     optionType = "path"
+    keyValue optionType = "key=value"
+    counter  optionType = "counter"
+    choice   optionType = "choice"
 
     indentSize = 4
 
-    longPrefix  = "--"
-    shortPrefix = "-"
+    longPrefix   = "--"
+    shortPrefix  = "-"
+    endOfOptions = "--"
+
+    negationPrefix = "no-"
+
+    defaultSeparator = ","
 )
 
 type Option struct {
-    long     string
-    desc     string
-    short    string
-    used     bool
-    required bool
-    argType  optionType
-    defVal   *string
-    setter   func(string) error
+    long         string
+    desc         string
+    short        string
+    aliases      []string
+    used         bool
+    required     bool
+    hidden       bool
+    persistent   bool
+    builtin      bool
+    env          string
+    deprecation  *deprecation
+    negatable    bool
+    multi        bool
+    separator    string
+    unique       bool
+    choices      []string
+    anyCase      bool
+    keys         map[string]bool
+    source       Source
+    values       []string
+    argType      optionType
+    defVals      []string
+    validators   []Validator
+    transformers []Transformer
+    setter       func(string) error
+    clear        func()
+    restore      func()
 }
 
 func (o *Option) expects() string {
-    switch o.argType {
-    case flag:
+    switch {
+    case !o.takesValue():
         return ""
+    case o.argType == keyValue:
+        return "<" + string(o.argType) + ">"
+    case o.multi:
+        return "<" + string(o.argType) + ">..."
     default:
         return "<" + string(o.argType) + ">"
     }
 }
 
+func (o *Option) takesValue() bool {
+    return o.argType != flag && o.argType != counter
+}
+
+// value used when the option is given without one
+func (o *Option) implicitValue() string {
+    if o.argType == counter {
+        return ""
+    }
+    return "true"
+}
+
 func (o *Option) defaultValue() string {
-    if o.defVal != nil {
-        return fmt.Sprintf("(default %s)", *o.defVal)
+    switch {
+    case len(o.defVals) == 0:
+        return ""
+    case o.multi:
+        return fmt.Sprintf("(default [%s])", strings.Join(o.defVals, ", "))
+    default:
+        return fmt.Sprintf("(default %s)", o.defVals[0])
+    }
+}
+
+func (o *Option) negation() string {
+    return longPrefix + negationPrefix + strings.TrimPrefix(o.long, longPrefix)
+}
+
+// long name if available, short otherwise
+func (o *Option) name() string {
+    if o.long != "" {
+        return o.long
+    }
+    return o.short
+}
+
+// explicitly bound environment variable or one derived from the prefix and long name
+func (o *Option) envName(prefix string) string {
+    if o.env != "" || o.builtin || prefix == "" || o.long == "" {
+        return o.env
+    }
+    name := strings.Replace(strings.TrimPrefix(o.long, longPrefix), "-", "_", -1)
+    return strings.TrimSuffix(prefix, "_") + "_" + strings.ToUpper(name)
+}
+
+// all names the option is registered under
+func (o *Option) names() []string {
+    var names []string
+    if o.long != "" {
+        names = append(names, o.long)
+        if o.negatable {
+            names = append(names, o.negation())
+        }
+    }
+    if o.short != "" {
+        names = append(names, o.short)
+    }
+    return append(names, o.aliases...)
+}
+
+func (o *Option) longAliases() []string {
+    var aliases []string
+    for _, alias := range o.aliases {
+        if strings.HasPrefix(alias, longPrefix) {
+            aliases = append(aliases, alias)
+        }
     }
-    return ""
+    return aliases
+}
+
+func (o *Option) shortAliases() []string {
+    var aliases []string
+    for _, alias := range o.aliases {
+        if !strings.HasPrefix(alias, longPrefix) {
+            aliases = append(aliases, alias)
+        }
+    }
+    return aliases
 }
 
 func (o *Option) trigger() string {
-    return fmt.Sprintf("%s, %s %s", o.long, o.short, o.expects())
+    var names []string
+    if o.long != "" {
+        if o.negatable {
+            names = append(names, longPrefix+"["+negationPrefix+"]"+strings.TrimPrefix(o.long, longPrefix))
+        } else {
+            names = append(names, o.long)
+        }
+    }
+    names = append(names, o.longAliases()...)
+    if o.short != "" {
+        names = append(names, o.short)
+    }
+    names = append(names, o.shortAliases()...)
+    return fmt.Sprintf("%s %s", strings.Join(names, ", "), o.expects())
 }
 
 func (o *Option) description() string {
+    if len(o.choices) > 0 {
+        return fmt.Sprintf("%s {%s} %s", o.desc, strings.Join(o.choices, "|"), o.defaultValue())
+    }
     return fmt.Sprintf("%s %s", o.desc, o.defaultValue())
 }
 
-func (o *Option) set(value string) error {
-    if err := o.setter(value); err != nil {
+func (o *Option) Choices() []string {
+    return o.choices
+}
+
+func (o *Option) choose(value string) (string, error) {
+    for _, choice := range o.choices {
+        if value == choice || (o.anyCase && strings.EqualFold(value, choice)) {
+            return choice, nil
+        }
+    }
+    return value, fmt.Errorf("invalid choice %q, expected one of %s", value, strings.Join(o.choices, ", "))
+}
+
+// values from sources of lower precedence are ignored, values from higher replace the current ones
+func (o *Option) set(source Source, value string) error {
+    if source < o.source {
+        return nil
+    }
+    if source > o.source {
+        o.source = source
+        o.values, o.keys = nil, nil
+        if o.clear != nil {
+            o.clear()
+        }
+    }
+    // defaults are given one by one already
+    values := []string{value}
+    if o.separator != "" && source != DefaultValue {
+        values = strings.Split(value, o.separator)
+    }
+    values, err := o.transform(values)
+    if err != nil {
         return err
     }
+    for _, value := range values {
+        if len(o.choices) > 0 {
+            chosen, err := o.choose(value)
+            if err != nil {
+                return err
+            }
+            value = chosen
+        }
+        if o.argType != counter {
+            if err := o.validate(value); err != nil {
+                return err
+            }
+        }
+        if o.argType == keyValue {
+            if err := o.checkKey(value); err != nil {
+                return err
+            }
+        }
+        if err := o.setter(value); err != nil {
+            return err
+        }
+        if o.multi {
+            o.values = append(o.values, value)
+        } else {
+            o.values = []string{value}
+        }
+    }
     o.used = true
     return nil
 }
 
+func (o *Option) checkKey(value string) error {
+    pair := strings.SplitN(value, "=", 2)
+    if len(pair) != 2 || pair[0] == "" {
+        return fmt.Errorf("expected key=value, got %q", value)
+    }
+    if o.keys == nil {
+        o.keys = make(map[string]bool)
+    }
+    if o.unique && o.keys[pair[0]] {
+        return fmt.Errorf("duplicate key %q", pair[0])
+    }
+    o.keys[pair[0]] = true
+    return nil
+}
+
+// names of option being declared as they will be shown
+func declaredName(long string, short byte) string {
+    var names []string
+    if long != NoLong {
+        names = append(names, longPrefix+Escape(long))
+    }
+    if short != NoShort {
+        names = append(names, shortPrefix+string(short))
+    }
+    return strings.Join(names, "|")
+}
+
 func notNil(value interface{}, long string, short byte) interface{} {
     if value == nil {
-        panic("Value for option " + longPrefix + long + "|" + shortPrefix + string(short) + " can't be nil")
+        panic("Value for option " + declaredName(long, short) + " can't be nil")
     }
     return value
 }
 
+func bound(option *Option, value interface{}) *Option {
+    option.restore = restorer(value, "option "+strings.Join(option.names(), "|"))
+    return option
+}
+
+// forgets values of previous parsing, defaults are applied again when the option's level is entered
+func (o *Option) reset() {
+    o.used, o.source, o.values, o.keys = false, NotSet, nil, nil
+    if o.clear != nil {
+        o.clear()
+    }
+    if o.restore != nil {
+        o.restore()
+    }
+}
+
 func Required(option *Option) *Option {
     option.required = true
     return option
 }
 
-func newOption(optType optionType, long string, short byte, description string, setter func(string) error, defaultValue *string) *Option {
+// persistent options are accepted by all groups and commands below the one they are added to
+func Persistent(option *Option) *Option {
+    option.persistent = true
+    return option
+}
+
+func Hidden(option *Option) *Option {
+    option.hidden = true
+    return option
+}
+
+func Env(option *Option, name string) *Option {
+    option.env = name
+    return option
+}
+
+// options provided by the library itself are not bound to environment
+func builtin(option *Option) *Option {
+    option.builtin = true
+    return option
+}
+
+func Deprecated(option *Option, message string, replacement string) *Option {
+    option.deprecation = &deprecation{message: message, replacement: replacement}
+    return option
+}
+
+func Separated(option *Option, separator string) *Option {
+    option.separator = separator
+    return option
+}
+
+// single-letter aliases are short, others long; they have to be set before the option is added
+func Aliases(option *Option, aliases ...string) *Option {
+    for _, alias := range aliases {
+        if len(alias) == 1 {
+            option.aliases = append(option.aliases, shortPrefix+alias)
+        } else {
+            option.aliases = append(option.aliases, longPrefix+Escape(alias))
+        }
+    }
+    return option
+}
+
+func UniqueKeys(option *Option) *Option {
+    option.unique = true
+    return option
+}
+
+func IgnoreCase(option *Option) *Option {
+    option.anyCase = true
+    return option
+}
+
+func newOption(optType optionType, long string, short byte, description string, setter func(string) error, defaults []string) *Option {
     long = Escape(long)
+    if long == NoLong && short == NoShort {
+        panic("Option '" + description + "' needs a long or a short name")
+    }
     option := &Option{
-        long:    longPrefix + long,
-        short:   shortPrefix + string(short),
         desc:    Sentence(description),
         argType: optType,
-        defVal:  defaultValue,
+        defVals: defaults,
         setter:  setter}
-    if defaultValue != nil {
-        option.set(*defaultValue)
+    if long != NoLong {
+        option.long = longPrefix + long
     }
+    if short != NoShort {
+        option.short = shortPrefix + string(short)
+    }
+    return option
+}
+
+func newSliceOption(optType optionType, long string, short byte, description string, setter func(string) error, clear func(), defaults []string) *Option {
+    option := newOption(optType, long, short, description, setter, defaults)
+    option.multi = true
+    option.separator = defaultSeparator
+    option.clear = clear
     return option
 }
 
 func FlagOptFunc(handler func() error, long string, short byte, description string) *Option {
-    return newOption(flag, long, short, description, func(string) error {
+    return newOption(flag, long, short, description, func(val string) error {
+        enabled, err := strconv.ParseBool(val)
+        if err != nil {
+            return err
+        }
+        if !enabled {
+            return nil
+        }
         return handler()
     }, nil)
 }
 
 func FlagOpt(value *bool, long string, short byte, description string) *Option {
-    return FlagOptFunc(func() error {
-        val := true
-        *value = val
+    return bound(newOption(flag, long, short, description, func(val string) error {
+        enabled, err := strconv.ParseBool(val)
+        if err != nil {
+            return err
+        }
+        *value = enabled
         return nil
-    }, long, short, description)
+    }, nil), value)
+}
+
+func NegatableFlagOptFunc(handler func(bool) error, long string, short byte, description string, defaults ...bool) *Option {
+    var defVal []string
+    if len(defaults) > 0 {
+        converted := strconv.FormatBool(defaults[0])
+        defVal = []string{converted}
+    }
+    option := newOption(flag, long, short, description, func(val string) error {
+        enabled, err := strconv.ParseBool(val)
+        if err != nil {
+            return err
+        }
+        return handler(enabled)
+    }, defVal)
+    option.negatable = true
+    return option
+}
+
+func NegatableFlagOpt(value *bool, long string, short byte, description string, defaults ...bool) *Option {
+    return bound(NegatableFlagOptFunc(func(enabled bool) error {
+        *value = enabled
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func CountOptFunc(handler func(int) error, long string, short byte, description string) *Option {
+    count := 0
+    var option *Option
+    option = newOption(counter, long, short, description, func(val string) error {
+        next := count + 1
+        if val != "" {
+            number, err := strconv.Atoi(val)
+            if err != nil {
+                return err
+            }
+            next = number
+        }
+        // validators check the resulting count, not the occurrence
+        if err := option.validate(strconv.Itoa(next)); err != nil {
+            return fmt.Errorf("count %d %s", next, err)
+        }
+        count = next
+        return handler(count)
+    }, nil)
+    option.clear = func() {
+        count = 0
+    }
+    return option
+}
+
+func CountOpt(value *int, long string, short byte, description string) *Option {
+    return bound(CountOptFunc(func(count int) error {
+        *value = count
+        return nil
+    }, long, short, description), value)
 }
 
 func IntOptFunc(handler func(int64) error, long string, short byte, description string, defaults ...int64) *Option {
-    var defVal *string
+    var defVal []string
     if len(defaults) > 0 {
         converted := strconv.FormatInt(defaults[0], 10)
-        defVal = &converted
+        defVal = []string{converted}
     }
     return newOption(integer, long, short, description, func(val string) error {
         number, err := strconv.ParseInt(val, 10, 64)
@@ -122,10 +481,10 @@ func IntOptFunc(handler func(int64) error, long string, short byte, description
 }
 
 func IntOpt(value **int64, long string, short byte, description string, defaults ...int64) *Option {
-    return IntOptFunc(func(number int64) error {
+    return bound(IntOptFunc(func(number int64) error {
         *value = &number
         return nil
-    }, long, short, description, defaults...)
+    }, long, short, description, defaults...), value)
 }
 
 func RequiredIntOptFunc(handler func(int64) error, long string, short byte, description string, defaults ...int64) *Option {
@@ -134,17 +493,17 @@ func RequiredIntOptFunc(handler func(int64) error, long string, short byte, desc
 
 func RequiredIntOpt(value *int64, long string, short byte, description string, defaults ...int64) *Option {
     notNil(value, long, short)
-    return Required(IntOptFunc(func(number int64) error {
+    return bound(Required(IntOptFunc(func(number int64) error {
         *value = number
         return nil
-    }, long, short, description, defaults...))
+    }, long, short, description, defaults...)), value)
 }
 
 func FloatOptFunc(handler func(float64) error, long string, short byte, description string, defaults ...float64) *Option {
-    var defVal *string
+    var defVal []string
     if len(defaults) > 0 {
         converted := strconv.FormatFloat(defaults[0], 'f', 6, 64)
-        defVal = &converted
+        defVal = []string{converted}
     }
     return newOption(float, long, short, description, func(val string) error {
         number, err := strconv.ParseFloat(val, 64)
@@ -156,10 +515,10 @@ func FloatOptFunc(handler func(float64) error, long string, short byte, descript
 }
 
 func FloatOpt(value **float64, long string, short byte, description string, defaults ...float64) *Option {
-    return FloatOptFunc(func(number float64) error {
+    return bound(FloatOptFunc(func(number float64) error {
         *value = &number
         return nil
-    }, long, short, description, defaults...)
+    }, long, short, description, defaults...), value)
 }
 
 func RequiredFloatOptFunc(handler func(float64) error, long string, short byte, description string, defaults ...float64) *Option {
@@ -168,16 +527,16 @@ func RequiredFloatOptFunc(handler func(float64) error, long string, short byte,
 
 func RequiredFloatOpt(value *float64, long string, short byte, description string, defaults ...float64) *Option {
     notNil(value, long, short)
-    return Required(FloatOptFunc(func(number float64) error {
+    return bound(Required(FloatOptFunc(func(number float64) error {
         *value = number
         return nil
-    }, long, short, description, defaults...))
+    }, long, short, description, defaults...)), value)
 }
 
 func StringOptFunc(handler func(string) error, long string, short byte, description string, defaults ...string) *Option {
-    var defVal *string
+    var defVal []string
     if len(defaults) > 0 {
-        defVal = &defaults[0]
+        defVal = defaults[:1]
     }
     return newOption(value, long, short, description, func(val string) error {
         return handler(val)
@@ -185,10 +544,10 @@ func StringOptFunc(handler func(string) error, long string, short byte, descript
 }
 
 func StringOpt(value **string, long string, short byte, description string, defaults ...string) *Option {
-    return StringOptFunc(func(val string) error {
+    return bound(StringOptFunc(func(val string) error {
         *value = &val
         return nil
-    }, long, short, description, defaults...)
+    }, long, short, description, defaults...), value)
 }
 
 func RequiredStringOptFunc(handler func(string) error, long string, short byte, description string, defaults ...string) *Option {
@@ -197,17 +556,17 @@ func RequiredStringOptFunc(handler func(string) error, long string, short byte,
 
 func RequiredStringOpt(value *string, long string, short byte, description string, defaults ...string) *Option {
     notNil(value, long, short)
-    return Required(StringOptFunc(func(val string) error {
+    return bound(Required(StringOptFunc(func(val string) error {
         *value = val
         return nil
-    }, long, short, description, defaults...))
+    }, long, short, description, defaults...)), value)
 }
 
 func DurationOptFunc(handler func(time.Duration) error, long string, short byte, description string, defaults ...time.Duration) *Option {
-    var defVal *string
+    var defVal []string
     if len(defaults) > 0 {
         converted := fmt.Sprintf("%v", defaults[0])
-        defVal = &converted
+        defVal = []string{converted}
     }
     return newOption(duration, long, short, description, func(val string) error {
         duration, err := time.ParseDuration(val)
@@ -219,10 +578,10 @@ func DurationOptFunc(handler func(time.Duration) error, long string, short byte,
 }
 
 func DurationOpt(value **time.Duration, long string, short byte, description string, defaults ...time.Duration) *Option {
-    return DurationOptFunc(func(val time.Duration) error {
+    return bound(DurationOptFunc(func(val time.Duration) error {
         *value = &val
         return nil
-    }, long, short, description, defaults...)
+    }, long, short, description, defaults...), value)
 }
 
 func RequiredDurationOptFunc(handler func(time.Duration) error, long string, short byte, description string, defaults ...time.Duration) *Option {
@@ -231,16 +590,16 @@ func RequiredDurationOptFunc(handler func(time.Duration) error, long string, sho
 
 func RequiredDurationOpt(value *time.Duration, long string, short byte, description string, defaults ...time.Duration) *Option {
     notNil(value, long, short)
-    return Required(DurationOptFunc(func(val time.Duration) error {
+    return bound(Required(DurationOptFunc(func(val time.Duration) error {
         *value = val
         return nil
-    }, long, short, description, defaults...))
+    }, long, short, description, defaults...)), value)
 }
 
 func PathOptFunc(handler func(string) error, long string, short byte, description string, defaults ...string) *Option {
-    var defVal *string
+    var defVal []string
     if len(defaults) > 0 {
-        defVal = &defaults[0]
+        defVal = defaults[:1]
     }
     return newOption(path, long, short, description, func(val string) error {
         return handler(val)
@@ -248,10 +607,10 @@ func PathOptFunc(handler func(string) error, long string, short byte, descriptio
 }
 
 func PathOpt(value **string, long string, short byte, description string, defaults ...string) *Option {
-    return PathOptFunc(func(val string) error {
+    return bound(PathOptFunc(func(val string) error {
         *value = &val
         return nil
-    }, long, short, description, defaults...)
+    }, long, short, description, defaults...), value)
 }
 
 func RequiredPathOptFunc(handler func(string) error, long string, short byte, description string, defaults ...string) *Option {
@@ -260,8 +619,264 @@ func RequiredPathOptFunc(handler func(string) error, long string, short byte, de
 
 func RequiredPathOpt(value *string, long string, short byte, description string, defaults ...string) *Option {
     notNil(value, long, short)
-    return Required(PathOptFunc(func(val string) error {
+    return bound(Required(PathOptFunc(func(val string) error {
+        *value = val
+        return nil
+    }, long, short, description, defaults...)), value)
+}
+
+func StringSliceOptFunc(handler func([]string) error, long string, short byte, description string, defaults ...string) *Option {
+    var values []string
+    return newSliceOption(value, long, short, description, func(val string) error {
+        values = append(values, val)
+        return handler(values)
+    }, func() {
+        values = nil
+    }, defaults)
+}
+
+func StringSliceOpt(value *[]string, long string, short byte, description string, defaults ...string) *Option {
+    notNil(value, long, short)
+    return bound(StringSliceOptFunc(func(values []string) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredStringSliceOptFunc(handler func([]string) error, long string, short byte, description string, defaults ...string) *Option {
+    return Required(StringSliceOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredStringSliceOpt(value *[]string, long string, short byte, description string, defaults ...string) *Option {
+    return Required(StringSliceOpt(value, long, short, description, defaults...))
+}
+
+func IntSliceOptFunc(handler func([]int64) error, long string, short byte, description string, defaults ...int64) *Option {
+    var defVals []string
+    for _, number := range defaults {
+        defVals = append(defVals, strconv.FormatInt(number, 10))
+    }
+    var values []int64
+    return newSliceOption(integer, long, short, description, func(val string) error {
+        number, err := strconv.ParseInt(val, 10, 64)
+        if err != nil {
+            return err
+        }
+        values = append(values, number)
+        return handler(values)
+    }, func() {
+        values = nil
+    }, defVals)
+}
+
+func IntSliceOpt(value *[]int64, long string, short byte, description string, defaults ...int64) *Option {
+    notNil(value, long, short)
+    return bound(IntSliceOptFunc(func(values []int64) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredIntSliceOptFunc(handler func([]int64) error, long string, short byte, description string, defaults ...int64) *Option {
+    return Required(IntSliceOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredIntSliceOpt(value *[]int64, long string, short byte, description string, defaults ...int64) *Option {
+    return Required(IntSliceOpt(value, long, short, description, defaults...))
+}
+
+func FloatSliceOptFunc(handler func([]float64) error, long string, short byte, description string, defaults ...float64) *Option {
+    var defVals []string
+    for _, number := range defaults {
+        defVals = append(defVals, strconv.FormatFloat(number, 'f', 6, 64))
+    }
+    var values []float64
+    return newSliceOption(float, long, short, description, func(val string) error {
+        number, err := strconv.ParseFloat(val, 64)
+        if err != nil {
+            return err
+        }
+        values = append(values, number)
+        return handler(values)
+    }, func() {
+        values = nil
+    }, defVals)
+}
+
+func FloatSliceOpt(value *[]float64, long string, short byte, description string, defaults ...float64) *Option {
+    notNil(value, long, short)
+    return bound(FloatSliceOptFunc(func(values []float64) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredFloatSliceOptFunc(handler func([]float64) error, long string, short byte, description string, defaults ...float64) *Option {
+    return Required(FloatSliceOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredFloatSliceOpt(value *[]float64, long string, short byte, description string, defaults ...float64) *Option {
+    return Required(FloatSliceOpt(value, long, short, description, defaults...))
+}
+
+func DurationSliceOptFunc(handler func([]time.Duration) error, long string, short byte, description string, defaults ...time.Duration) *Option {
+    var defVals []string
+    for _, duration := range defaults {
+        defVals = append(defVals, fmt.Sprintf("%v", duration))
+    }
+    var values []time.Duration
+    return newSliceOption(duration, long, short, description, func(val string) error {
+        duration, err := time.ParseDuration(val)
+        if err != nil {
+            return err
+        }
+        values = append(values, duration)
+        return handler(values)
+    }, func() {
+        values = nil
+    }, defVals)
+}
+
+func DurationSliceOpt(value *[]time.Duration, long string, short byte, description string, defaults ...time.Duration) *Option {
+    notNil(value, long, short)
+    return bound(DurationSliceOptFunc(func(values []time.Duration) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredDurationSliceOptFunc(handler func([]time.Duration) error, long string, short byte, description string, defaults ...time.Duration) *Option {
+    return Required(DurationSliceOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredDurationSliceOpt(value *[]time.Duration, long string, short byte, description string, defaults ...time.Duration) *Option {
+    return Required(DurationSliceOpt(value, long, short, description, defaults...))
+}
+
+func PathSliceOptFunc(handler func([]string) error, long string, short byte, description string, defaults ...string) *Option {
+    var values []string
+    return newSliceOption(path, long, short, description, func(val string) error {
+        values = append(values, val)
+        return handler(values)
+    }, func() {
+        values = nil
+    }, defaults)
+}
+
+func PathSliceOpt(value *[]string, long string, short byte, description string, defaults ...string) *Option {
+    notNil(value, long, short)
+    return bound(PathSliceOptFunc(func(values []string) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredPathSliceOptFunc(handler func([]string) error, long string, short byte, description string, defaults ...string) *Option {
+    return Required(PathSliceOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredPathSliceOpt(value *[]string, long string, short byte, description string, defaults ...string) *Option {
+    return Required(PathSliceOpt(value, long, short, description, defaults...))
+}
+
+func MapOptFunc(converter func(string) (interface{}, error), handler func(map[string]interface{}) error, long string, short byte, description string, defaults ...string) *Option {
+    values := make(map[string]interface{})
+    option := newSliceOption(keyValue, long, short, description, func(val string) error {
+        pair := strings.SplitN(val, "=", 2)
+        if len(pair) != 2 {
+            return fmt.Errorf("expected key=value, got %q", val)
+        }
+        converted, err := converter(pair[1])
+        if err != nil {
+            return err
+        }
+        values[pair[0]] = converted
+        return handler(values)
+    }, func() {
+        values = make(map[string]interface{})
+    }, defaults)
+    option.separator = ""
+    return option
+}
+
+func MapOpt(value *map[string]interface{}, converter func(string) (interface{}, error), long string, short byte, description string, defaults ...string) *Option {
+    notNil(value, long, short)
+    return bound(MapOptFunc(converter, func(values map[string]interface{}) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredMapOptFunc(converter func(string) (interface{}, error), handler func(map[string]interface{}) error, long string, short byte, description string, defaults ...string) *Option {
+    return Required(MapOptFunc(converter, handler, long, short, description, defaults...))
+}
+
+func RequiredMapOpt(value *map[string]interface{}, converter func(string) (interface{}, error), long string, short byte, description string, defaults ...string) *Option {
+    return Required(MapOpt(value, converter, long, short, description, defaults...))
+}
+
+func StringMapOptFunc(handler func(map[string]string) error, long string, short byte, description string, defaults ...string) *Option {
+    values := make(map[string]string)
+    option := newSliceOption(keyValue, long, short, description, func(val string) error {
+        pair := strings.SplitN(val, "=", 2)
+        if len(pair) != 2 {
+            return fmt.Errorf("expected key=value, got %q", val)
+        }
+        values[pair[0]] = pair[1]
+        return handler(values)
+    }, func() {
+        values = make(map[string]string)
+    }, defaults)
+    option.separator = ""
+    return option
+}
+
+func StringMapOpt(value *map[string]string, long string, short byte, description string, defaults ...string) *Option {
+    notNil(value, long, short)
+    return bound(StringMapOptFunc(func(values map[string]string) error {
+        *value = values
+        return nil
+    }, long, short, description, defaults...), value)
+}
+
+func RequiredStringMapOptFunc(handler func(map[string]string) error, long string, short byte, description string, defaults ...string) *Option {
+    return Required(StringMapOptFunc(handler, long, short, description, defaults...))
+}
+
+func RequiredStringMapOpt(value *map[string]string, long string, short byte, description string, defaults ...string) *Option {
+    return Required(StringMapOpt(value, long, short, description, defaults...))
+}
+
+func ChoiceOptFunc(handler func(string) error, choices []string, long string, short byte, description string, defaults ...string) *Option {
+    if len(choices) == 0 {
+        panic("Choices for option " + declaredName(long, short) + " can't be empty")
+    }
+    var defVal []string
+    if len(defaults) > 0 {
+        defVal = defaults[:1]
+    }
+    option := newOption(choice, long, short, description, func(val string) error {
+        return handler(val)
+    }, defVal)
+    option.choices = choices
+    return option
+}
+
+func ChoiceOpt(value **string, choices []string, long string, short byte, description string, defaults ...string) *Option {
+    return bound(ChoiceOptFunc(func(val string) error {
+        *value = &val
+        return nil
+    }, choices, long, short, description, defaults...), value)
+}
+
+func RequiredChoiceOptFunc(handler func(string) error, choices []string, long string, short byte, description string, defaults ...string) *Option {
+    return Required(ChoiceOptFunc(handler, choices, long, short, description, defaults...))
+}
+
+func RequiredChoiceOpt(value *string, choices []string, long string, short byte, description string, defaults ...string) *Option {
+    notNil(value, long, short)
+    return bound(Required(ChoiceOptFunc(func(val string) error {
         *value = val
         return nil
-    }, long, short, description, defaults...))
+    }, choices, long, short, description, defaults...)), value)
 }
@@ -0,0 +1,166 @@
+package cli
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// rewrites a single value of an option before it is validated and set, may expand it into more values
+type Transformer func(string) ([]string, error)
+
+// transformers are run in the given order, each one on all values produced by the previous one
+func Transform(option *Option, transformers ...Transformer) *Option {
+    for _, transformer := range transformers {
+        if transformer == nil {
+            panic("Transformer of option " + option.name() + " can't be nil")
+        }
+        option.transformers = append(option.transformers, transformer)
+    }
+    return option
+}
+
+func (o *Option) transform(values []string) ([]string, error) {
+    for _, transformer := range o.transformers {
+        var transformed []string
+        for _, value := range values {
+            results, err := transformer(value)
+            if err != nil {
+                return nil, err
+            }
+            transformed = append(transformed, results...)
+        }
+        values = transformed
+    }
+    if !o.multi && len(values) != 1 {
+        return nil, fmt.Errorf("expected single value, got %d", len(values))
+    }
+    return values, nil
+}
+
+// expands leading ~ to home directory and $VAR or ${VAR} to values of environment variables
+func ExpandPath() Transformer {
+    return func(val string) ([]string, error) {
+        if val == "~" || strings.HasPrefix(val, "~/") || strings.HasPrefix(val, "~"+string(filepath.Separator)) {
+            home, err := os.UserHomeDir()
+            if err != nil {
+                return nil, err
+            }
+            val = home + val[1:]
+        }
+        return []string{os.ExpandEnv(val)}, nil
+    }
+}
+
+func AbsolutePath() Transformer {
+    return func(val string) ([]string, error) {
+        absolute, err := filepath.Abs(val)
+        if err != nil {
+            return nil, err
+        }
+        return []string{absolute}, nil
+    }
+}
+
+// expands glob patterns into matching paths, other values are kept as they are
+func Glob() Transformer {
+    return func(val string) ([]string, error) {
+        if !strings.ContainsAny(val, "*?[") {
+            return []string{val}, nil
+        }
+        matches, err := filepath.Glob(val)
+        if err != nil {
+            return nil, fmt.Errorf("invalid pattern %s: %s", val, err)
+        }
+        if len(matches) == 0 {
+            return nil, fmt.Errorf("no path matches %s", val)
+        }
+        return matches, nil
+    }
+}
+
+func stat(val string) (os.FileInfo, error) {
+    info, err := os.Stat(val)
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("path %s does not exist", val)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("path %s is not accessible", val)
+    }
+    return info, nil
+}
+
+func PathExists() Validator {
+    return func(val string) error {
+        _, err := stat(val)
+        return err
+    }
+}
+
+// path has to exist and be a regular file
+func IsFile() Validator {
+    return func(val string) error {
+        info, err := stat(val)
+        if err == nil && !info.Mode().IsRegular() {
+            return fmt.Errorf("path %s is not a file", val)
+        }
+        return err
+    }
+}
+
+// path has to exist and be a directory
+func IsDir() Validator {
+    return func(val string) error {
+        info, err := stat(val)
+        if err == nil && !info.IsDir() {
+            return fmt.Errorf("path %s is not a directory", val)
+        }
+        return err
+    }
+}
+
+// path has to exist and be readable
+func Readable() Validator {
+    return func(val string) error {
+        if _, err := stat(val); err != nil {
+            return err
+        }
+        file, err := os.Open(val)
+        if err != nil {
+            return fmt.Errorf("path %s is not readable", val)
+        }
+        return file.Close()
+    }
+}
+
+// existing path has to be writable, otherwise its directory has to be
+func Writable() Validator {
+    return func(val string) error {
+        info, err := os.Stat(val)
+        if os.IsNotExist(err) {
+            return writableDir(filepath.Dir(val), val)
+        }
+        if err != nil {
+            return fmt.Errorf("path %s is not accessible", val)
+        }
+        if info.IsDir() {
+            return writableDir(val, val)
+        }
+        file, err := os.OpenFile(val, os.O_WRONLY, 0)
+        if err != nil {
+            return fmt.Errorf("path %s is not writable", val)
+        }
+        return file.Close()
+    }
+}
+
+func writableDir(dir string, val string) error {
+    probe, err := ioutil.TempFile(dir, ".writable")
+    if err != nil {
+        return fmt.Errorf("path %s is not writable", val)
+    }
+    probe.Close()
+    return os.Remove(probe.Name())
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "github.com/rwn3120/go-table"
+)
+
+// origin of option value, sources of higher precedence override the lower ones
+type Source int
+
+const (
+    NotSet Source = iota
+    DefaultValue
+    ConfigFile
+    Environment
+    CommandLine
+)
+
+func (s Source) String() string {
+    switch s {
+    case DefaultValue:
+        return "default"
+    case ConfigFile:
+        return "config file"
+    case Environment:
+        return "environment"
+    case CommandLine:
+        return "command line"
+    default:
+        return "unset"
+    }
+}
+
+func (o *Option) Source() Source {
+    return o.source
+}
+
+// effective values as they were given
+func (o *Option) Values() []string {
+    return o.values
+}
+
+// applies defaults, configuration and environment to options of the level
+func (p *parser) resolve(cli cmdInfo) error {
+    p.levels = append(p.levels, cli)
+    for _, option := range uniqueOptions(cli) {
+        for _, value := range option.defVals {
+            if err := option.set(DefaultValue, value); err != nil {
+                return fmt.Errorf("Invalid default %s value %q: %s", option.name(), value, err)
+            }
+        }
+    }
+    if err := p.applyConfig(cli); err != nil {
+        return err
+    }
+    return p.applyEnvironment(cli)
+}
+
+// applies values of environment variables bound to options of the level
+func (p *parser) applyEnvironment(cli cmdInfo) error {
+    for _, option := range uniqueOptions(cli) {
+        name := option.envName(p.envPrefix)
+        if name == "" {
+            continue
+        }
+        if value, found := os.LookupEnv(name); found {
+            if err := option.set(Environment, value); err != nil {
+                return fmt.Errorf("Invalid %s value %q from %s: %s", option.name(), value, name, err)
+            }
+        }
+    }
+    return nil
+}
+
+// prints effective values of options and their sources
+func (p *parser) print() {
+    for _, level := range p.levels {
+        values := table.New(96, 16, indentSize, false)
+        for _, option := range uniqueOptions(level) {
+            if !option.builtin {
+                values.Row(option.name(), strings.TrimSpace(fmt.Sprintf("%s (%s)", strings.Join(option.values, ", "), option.source)))
+            }
+        }
+        if values.Size() > 0 {
+            Info(level.trigger() + ":")
+            values.Print()
+        }
+    }
+}
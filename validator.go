@@ -0,0 +1,97 @@
+package cli
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// checks a single value of an option before it is set, defaults included
+type Validator func(string) error
+
+// validators are run in the given order, the first failure rejects the value;
+// counters are validated by the resulting count, flags can't be validated
+func Validate(option *Option, validators ...Validator) *Option {
+    if option.argType == flag {
+        panic("Flag option " + option.name() + " can't have validators")
+    }
+    for _, validator := range validators {
+        if validator == nil {
+            panic("Validator of option " + option.name() + " can't be nil")
+        }
+        option.validators = append(option.validators, validator)
+    }
+    return option
+}
+
+func (o *Option) validate(value string) error {
+    for _, validator := range o.validators {
+        if err := validator(value); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func IntRange(min int64, max int64) Validator {
+    return func(val string) error {
+        number, err := strconv.ParseInt(val, 10, 64)
+        if err != nil {
+            return err
+        }
+        if number < min || number > max {
+            return fmt.Errorf("must be between %d and %d", min, max)
+        }
+        return nil
+    }
+}
+
+func FloatRange(min float64, max float64) Validator {
+    return func(val string) error {
+        number, err := strconv.ParseFloat(val, 64)
+        if err != nil {
+            return err
+        }
+        if number < min || number > max {
+            return fmt.Errorf("must be between %v and %v", min, max)
+        }
+        return nil
+    }
+}
+
+func DurationRange(min time.Duration, max time.Duration) Validator {
+    return func(val string) error {
+        duration, err := time.ParseDuration(val)
+        if err != nil {
+            return err
+        }
+        if duration < min || duration > max {
+            return fmt.Errorf("must be between %v and %v", min, max)
+        }
+        return nil
+    }
+}
+
+// value has to match the regular expression, invalid expression panics
+func Matches(pattern string) Validator {
+    re := regexp.MustCompile(pattern)
+    return func(val string) error {
+        if !re.MatchString(val) {
+            return fmt.Errorf("must match %s", pattern)
+        }
+        return nil
+    }
+}
+
+// value can't be empty or blank
+func NotEmpty() Validator {
+    return func(val string) error {
+        if strings.TrimSpace(val) == "" {
+            return errors.New("must not be empty")
+        }
+        return nil
+    }
+}